@@ -0,0 +1,440 @@
+// Package ast declares the syntax tree of ez scripts, with a parser that
+// builds it from source and a printer that turns it back into source.
+//
+// The language is line oriented, and so is the tree: a File is a list of
+// statements, one per line. A block is the statement opening it, such as an
+// IfStmt without a Then or a FuncStmt, the statements of its body and an
+// EndStmt, in the order of the lines they were written on.
+package ast
+
+import "strconv"
+
+// Pos is a position in a script: a 1-based line and a 1-based byte column.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Col)
+}
+
+// add returns the position n bytes further along the line.
+func (p Pos) add(n int) Pos {
+	return Pos{Line: p.Line, Col: p.Col + n}
+}
+
+// Node is any node of the tree. End is the position just past it.
+type Node interface {
+	Pos() Pos
+	End() Pos
+}
+
+// Expr is an operand or an expression computing one.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is the statement of one line.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// File is a parsed script.
+type File struct {
+	Stmts []Stmt
+}
+
+func (f *File) Pos() Pos {
+	if len(f.Stmts) == 0 {
+		return Pos{}
+	}
+	return f.Stmts[0].Pos()
+}
+
+func (f *File) End() Pos {
+	if len(f.Stmts) == 0 {
+		return Pos{}
+	}
+	return f.Stmts[len(f.Stmts)-1].End()
+}
+
+// Comment is a '#' comment, which runs to the end of its line. Text holds
+// it as written, '#' included.
+type Comment struct {
+	Hash Pos
+	Text string
+}
+
+func (c *Comment) Pos() Pos { return c.Hash }
+func (c *Comment) End() Pos { return c.Hash.add(len(c.Text)) }
+
+// Expressions.
+type (
+	// Ident is an identifier: a variable, a parameter or the name of a
+	// function, be it builtin, declared by the script or provided by its
+	// host. Builtin operators such as + are functions too.
+	Ident struct {
+		NamePos Pos
+		Name    string
+	}
+
+	// BasicLit is an int, float, bool or string literal. Value is as
+	// written, so strings keep their quotes and escapes.
+	BasicLit struct {
+		ValuePos Pos
+		Kind     LitKind
+		Value    string
+	}
+
+	// Label is a goto target, written with a leading '~'.
+	Label struct {
+		TildePos Pos
+		Name     string // without the '~'
+	}
+
+	// BadExpr is a word that is no identifier, literal or label.
+	BadExpr struct {
+		From Pos
+		Text string
+	}
+
+	// ParenExpr is a parenthesised expression.
+	ParenExpr struct {
+		Lparen Pos
+		X      Expr
+		Rparen Pos
+	}
+
+	// CallExpr calls Fun with Args. The function may be written anywhere
+	// among its arguments: FunIndex is the number of arguments before it,
+	// 1 for 'a + b' and 0 for 'len a'.
+	CallExpr struct {
+		Fun      *Ident
+		Args     []Expr
+		FunIndex int
+	}
+
+	// BinaryExpr is an infix operator applied to X and Y, as in a chain
+	// such as 'a + b * c', which groups by operator precedence.
+	BinaryExpr struct {
+		X     Expr
+		OpPos Pos
+		Op    string
+		Y     Expr
+	}
+
+	// ArrayLit is an array literal. It can only be assigned.
+	ArrayLit struct {
+		Lbrack Pos
+		Elts   []Expr
+		Rbrack Pos
+	}
+)
+
+// LitKind is the type of a BasicLit.
+type LitKind int
+
+const (
+	Int LitKind = iota
+	Float
+	Bool
+	String
+)
+
+func (x *Ident) Pos() Pos      { return x.NamePos }
+func (x *BasicLit) Pos() Pos   { return x.ValuePos }
+func (x *Label) Pos() Pos      { return x.TildePos }
+func (x *BadExpr) Pos() Pos    { return x.From }
+func (x *ParenExpr) Pos() Pos  { return x.Lparen }
+func (x *BinaryExpr) Pos() Pos { return x.X.Pos() }
+func (x *ArrayLit) Pos() Pos   { return x.Lbrack }
+
+func (x *CallExpr) Pos() Pos {
+	if x.FunIndex > 0 {
+		return x.Args[0].Pos()
+	}
+	return x.Fun.Pos()
+}
+
+func (x *Ident) End() Pos      { return x.NamePos.add(len(x.Name)) }
+func (x *BasicLit) End() Pos   { return x.ValuePos.add(len(x.Value)) }
+func (x *Label) End() Pos      { return x.TildePos.add(1 + len(x.Name)) }
+func (x *BadExpr) End() Pos    { return x.From.add(len(x.Text)) }
+func (x *ParenExpr) End() Pos  { return x.Rparen.add(1) }
+func (x *BinaryExpr) End() Pos { return x.Y.End() }
+func (x *ArrayLit) End() Pos   { return x.Rbrack.add(1) }
+
+func (x *CallExpr) End() Pos {
+	if x.FunIndex == len(x.Args) {
+		return x.Fun.End()
+	}
+	return x.Args[len(x.Args)-1].End()
+}
+
+func (*Ident) exprNode()      {}
+func (*BasicLit) exprNode()   {}
+func (*Label) exprNode()      {}
+func (*BadExpr) exprNode()    {}
+func (*ParenExpr) exprNode()  {}
+func (*CallExpr) exprNode()   {}
+func (*BinaryExpr) exprNode() {}
+func (*ArrayLit) exprNode()   {}
+
+// Statements. Comment is the comment ending the line, if any.
+type (
+	// CommentStmt is a line holding only a comment.
+	CommentStmt struct {
+		Comment *Comment
+	}
+
+	// ParamsStmt declares the in parameters of a script: a line of
+	// identifiers only.
+	ParamsStmt struct {
+		Names   []*Ident
+		Comment *Comment
+	}
+
+	// OutStmt declares the out parameters of a script.
+	OutStmt struct {
+		Out     Pos
+		Names   []*Ident
+		Comment *Comment
+	}
+
+	// AssignStmt assigns the result of Rhs to Lhs. Only a call may have
+	// more than one result.
+	AssignStmt struct {
+		Lhs     []*Ident
+		Assign  Pos
+		Rhs     Expr
+		Comment *Comment
+	}
+
+	// ExprStmt is an expression run for its effects, normally a call whose
+	// results, if any, are dropped.
+	ExprStmt struct {
+		X       Expr
+		Comment *Comment
+	}
+
+	// LabelStmt declares a label at the next op.
+	LabelStmt struct {
+		Label   *Label
+		Comment *Comment
+	}
+
+	// IfStmt runs Then if Cond holds. Without Then it opens an if block.
+	IfStmt struct {
+		If      Pos
+		Cond    Expr
+		Then    Stmt
+		Comment *Comment
+	}
+
+	// ElseStmt starts the final branch of an if block, or with If another
+	// conditional one.
+	ElseStmt struct {
+		Else    Pos
+		If      *IfStmt
+		Comment *Comment
+	}
+
+	// WhileStmt opens a loop that runs while Cond holds.
+	WhileStmt struct {
+		While   Pos
+		Cond    Expr
+		Comment *Comment
+	}
+
+	// ForStmt opens a loop that runs with Var set to every int from From
+	// up to and including To.
+	ForStmt struct {
+		For     Pos
+		Var     *Ident
+		From    Expr
+		To      Expr
+		Comment *Comment
+	}
+
+	// FuncStmt opens the body of a function.
+	FuncStmt struct {
+		Func    Pos
+		Name    *Ident
+		Params  []*Ident
+		Comment *Comment
+	}
+
+	// ReturnStmt returns Results from a function.
+	ReturnStmt struct {
+		Return  Pos
+		Results []Expr
+		Comment *Comment
+	}
+
+	// BranchStmt is a break or continue.
+	BranchStmt struct {
+		TokPos  Pos
+		Tok     string
+		Comment *Comment
+	}
+
+	// EndStmt closes the innermost block or function.
+	EndStmt struct {
+		EndPos  Pos
+		Comment *Comment
+	}
+)
+
+func (s *CommentStmt) Pos() Pos { return s.Comment.Pos() }
+func (s *ParamsStmt) Pos() Pos  { return s.Names[0].Pos() }
+func (s *OutStmt) Pos() Pos     { return s.Out }
+func (s *AssignStmt) Pos() Pos  { return s.Lhs[0].Pos() }
+func (s *ExprStmt) Pos() Pos    { return s.X.Pos() }
+func (s *LabelStmt) Pos() Pos   { return s.Label.Pos() }
+func (s *IfStmt) Pos() Pos      { return s.If }
+func (s *ElseStmt) Pos() Pos    { return s.Else }
+func (s *WhileStmt) Pos() Pos   { return s.While }
+func (s *ForStmt) Pos() Pos     { return s.For }
+func (s *FuncStmt) Pos() Pos    { return s.Func }
+func (s *ReturnStmt) Pos() Pos  { return s.Return }
+func (s *BranchStmt) Pos() Pos  { return s.TokPos }
+func (s *EndStmt) Pos() Pos     { return s.EndPos }
+
+// The End of a statement is that of its last token, not of its comment.
+
+func (s *CommentStmt) End() Pos { return s.Comment.End() }
+func (s *ParamsStmt) End() Pos  { return s.Names[len(s.Names)-1].End() }
+func (s *AssignStmt) End() Pos  { return s.Rhs.End() }
+func (s *ExprStmt) End() Pos    { return s.X.End() }
+func (s *LabelStmt) End() Pos   { return s.Label.End() }
+func (s *WhileStmt) End() Pos   { return s.Cond.End() }
+func (s *ForStmt) End() Pos     { return s.To.End() }
+func (s *BranchStmt) End() Pos  { return s.TokPos.add(len(s.Tok)) }
+func (s *EndStmt) End() Pos     { return s.EndPos.add(len("end")) }
+
+func (s *OutStmt) End() Pos {
+	if len(s.Names) == 0 {
+		return s.Out.add(len("out"))
+	}
+	return s.Names[len(s.Names)-1].End()
+}
+
+func (s *IfStmt) End() Pos {
+	if s.Then != nil {
+		return s.Then.End()
+	}
+	return s.Cond.End()
+}
+
+func (s *ElseStmt) End() Pos {
+	if s.If != nil {
+		return s.If.End()
+	}
+	return s.Else.add(len("else"))
+}
+
+func (s *FuncStmt) End() Pos {
+	if len(s.Params) == 0 {
+		return s.Name.End()
+	}
+	return s.Params[len(s.Params)-1].End()
+}
+
+func (s *ReturnStmt) End() Pos {
+	if len(s.Results) == 0 {
+		return s.Return.add(len("return"))
+	}
+	return s.Results[len(s.Results)-1].End()
+}
+
+func (*CommentStmt) stmtNode() {}
+func (*ParamsStmt) stmtNode()  {}
+func (*OutStmt) stmtNode()     {}
+func (*AssignStmt) stmtNode()  {}
+func (*ExprStmt) stmtNode()    {}
+func (*LabelStmt) stmtNode()   {}
+func (*IfStmt) stmtNode()      {}
+func (*ElseStmt) stmtNode()    {}
+func (*WhileStmt) stmtNode()   {}
+func (*ForStmt) stmtNode()     {}
+func (*FuncStmt) stmtNode()    {}
+func (*ReturnStmt) stmtNode()  {}
+func (*BranchStmt) stmtNode()  {}
+func (*EndStmt) stmtNode()     {}
+
+// Inspect calls f for node and, while f returns true, for each of the nodes
+// within it in source order. Comments are not visited.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *File:
+		for _, s := range n.Stmts {
+			Inspect(s, f)
+		}
+	case *ParenExpr:
+		Inspect(n.X, f)
+	case *CallExpr:
+		for i, arg := range n.Args {
+			if i == n.FunIndex {
+				Inspect(n.Fun, f)
+			}
+			Inspect(arg, f)
+		}
+		if n.FunIndex == len(n.Args) {
+			Inspect(n.Fun, f)
+		}
+	case *BinaryExpr:
+		Inspect(n.X, f)
+		Inspect(n.Y, f)
+	case *ArrayLit:
+		for _, elt := range n.Elts {
+			Inspect(elt, f)
+		}
+	case *ParamsStmt:
+		for _, name := range n.Names {
+			Inspect(name, f)
+		}
+	case *OutStmt:
+		for _, name := range n.Names {
+			Inspect(name, f)
+		}
+	case *AssignStmt:
+		for _, name := range n.Lhs {
+			Inspect(name, f)
+		}
+		Inspect(n.Rhs, f)
+	case *ExprStmt:
+		Inspect(n.X, f)
+	case *LabelStmt:
+		Inspect(n.Label, f)
+	case *IfStmt:
+		Inspect(n.Cond, f)
+		if n.Then != nil {
+			Inspect(n.Then, f)
+		}
+	case *ElseStmt:
+		if n.If != nil {
+			Inspect(n.If, f)
+		}
+	case *WhileStmt:
+		Inspect(n.Cond, f)
+	case *ForStmt:
+		Inspect(n.Var, f)
+		Inspect(n.From, f)
+		Inspect(n.To, f)
+	case *FuncStmt:
+		Inspect(n.Name, f)
+		for _, param := range n.Params {
+			Inspect(param, f)
+		}
+	case *ReturnStmt:
+		for _, result := range n.Results {
+			Inspect(result, f)
+		}
+	}
+}
@@ -0,0 +1,681 @@
+package ast
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Error is a syntax error. End is the position just past the text it
+// concerns; Hint, if set, suggests a fix.
+type Error struct {
+	Pos  Pos
+	End  Pos
+	Msg  string
+	Hint string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Parser parses a script one line at a time. Whether a word is a function
+// or an identifier decides how a line parses, so it remembers the functions
+// declared by the lines parsed so far. A copy of a Parser carries on from
+// the same lines independently of the original.
+type Parser struct {
+	// Funcs reports whether name is a function the script can call without
+	// declaring it, such as one of the language's or one provided by its
+	// host. Without it, an identifier followed by arguments is still taken
+	// for a call, but a call with only identifiers for arguments, making up
+	// a whole line, is taken for a declaration of in parameters.
+	Funcs func(name string) bool
+
+	declared map[string]bool
+}
+
+// ParseLine parses line number line of a script. It returns nil for a
+// blank line.
+func (p *Parser) ParseLine(line int, text string) (Stmt, error) {
+	tokens, err := scan(line, text)
+	if err != nil {
+		return nil, err
+	}
+	var comment *Comment
+	if n := len(tokens); n > 0 && tokens[n-1].kind == tokenComment {
+		comment = &Comment{Hash: tokens[n-1].pos, Text: tokens[n-1].text}
+		tokens = tokens[:n-1]
+	}
+	if len(tokens) == 0 {
+		if comment == nil {
+			return nil, nil
+		}
+		return &CommentStmt{Comment: comment}, nil
+	}
+	items, err := group(tokens)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := p.stmt(items)
+	if err != nil {
+		return nil, err
+	}
+	setComment(stmt, comment)
+	return stmt, nil
+}
+
+// ParseFile parses a whole script, stopping at the first syntax error.
+// funcs, which may be nil, reports the functions the script does not
+// declare, as Parser.Funcs does.
+func ParseFile(r io.Reader, funcs func(name string) bool) (*File, error) {
+	p := &Parser{Funcs: funcs}
+	f := &File{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		stmt, err := p.ParseLine(line, scanner.Text())
+		if err != nil {
+			return f, err
+		}
+		if stmt != nil {
+			f.Stmts = append(f.Stmts, stmt)
+		}
+	}
+	return f, scanner.Err()
+}
+
+// item is a token of a statement or a parenthesised group of them.
+type item struct {
+	tok    token
+	group  []item
+	rparen Pos // of a group
+}
+
+func (it item) pos() Pos {
+	return it.tok.pos
+}
+
+func (it item) end() Pos {
+	if it.group != nil {
+		return it.rparen.add(1)
+	}
+	return it.tok.end()
+}
+
+// is reports whether it is the word text.
+func (it item) is(text string) bool {
+	return it.group == nil && it.tok.kind == tokenWord && it.tok.text == text
+}
+
+// group nests the parenthesised groups of a statement's tokens. A group
+// item keeps its '(' as its token.
+func group(tokens []token) ([]item, error) {
+	stack := [][]item{nil}
+	var opens []token
+	for _, tok := range tokens {
+		switch tok.kind {
+		case tokenOpen:
+			stack = append(stack, nil)
+			opens = append(opens, tok)
+		case tokenClose:
+			if len(stack) == 1 {
+				return nil, errAt(tok.pos, tok.end(), "unmatched ')'")
+			}
+			g, open := stack[len(stack)-1], opens[len(opens)-1]
+			stack, opens = stack[:len(stack)-1], opens[:len(opens)-1]
+			if len(g) == 0 {
+				return nil, errAt(open.pos, tok.end(), "empty parentheses")
+			}
+			stack[len(stack)-1] = append(stack[len(stack)-1], item{tok: open, group: g, rparen: tok.pos})
+		default:
+			stack[len(stack)-1] = append(stack[len(stack)-1], item{tok: tok})
+		}
+	}
+	if len(stack) > 1 {
+		open := opens[len(opens)-1]
+		return nil, errAt(open.pos, open.end(), "unmatched '('")
+	}
+	return stack[0], nil
+}
+
+func (p *Parser) stmt(items []item) (Stmt, error) {
+	first := items[0]
+	switch {
+	case first.is("out"):
+		names, err := p.idents(items[1:], "expected identifier in out parameter list, got ")
+		if err != nil {
+			return nil, err
+		}
+		return &OutStmt{Out: first.pos(), Names: names}, nil
+	case first.is("func"):
+		return p.funcStmt(items)
+	case first.is("end"):
+		if len(items) > 1 {
+			return nil, errAt(items[1].pos(), items[len(items)-1].end(), "'end' can only be followed by a comment")
+		}
+		return &EndStmt{EndPos: first.pos()}, nil
+	case first.is("else"):
+		if len(items) == 1 {
+			return &ElseStmt{Else: first.pos()}, nil
+		}
+		if !items[1].is("if") {
+			return nil, errAt(items[1].pos(), items[len(items)-1].end(), "'else' can only be followed by 'if' or a comment")
+		}
+		s, err := p.ifStmt(items[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &ElseStmt{Else: first.pos(), If: s}, nil
+	case first.is("if"):
+		return p.ifStmt(items)
+	case first.is("while"):
+		if len(items) == 1 {
+			return nil, errAt(first.pos(), first.end(), "expected a bool expression after 'while'")
+		}
+		cond, err := p.expr(items[1:], "expression")
+		if err != nil {
+			return nil, err
+		}
+		return &WhileStmt{While: first.pos(), Cond: cond}, nil
+	case first.is("for"):
+		return p.forStmt(items)
+	case first.is("return"):
+		s := &ReturnStmt{Return: first.pos()}
+		for _, it := range items[1:] {
+			x, err := p.operand(it)
+			if err != nil {
+				return nil, err
+			}
+			s.Results = append(s.Results, x)
+		}
+		return s, nil
+	case first.is("break") || first.is("continue"):
+		if len(items) > 1 {
+			return nil, errAt(items[1].pos(), items[len(items)-1].end(), "'"+first.tok.text+"' takes no arguments")
+		}
+		return &BranchStmt{TokPos: first.pos(), Tok: first.tok.text}, nil
+	case first.group == nil && first.tok.kind == tokenWord && IsLabel(first.tok.text):
+		if len(items) > 1 {
+			return nil, errAt(items[1].pos(), items[len(items)-1].end(), "labels can only be followed by a comment")
+		}
+		return &LabelStmt{Label: &Label{TildePos: first.pos(), Name: first.tok.text[1:]}}, nil
+	}
+	return p.simpleStmt(items)
+}
+
+// simpleStmt parses an assignment, a declaration of in parameters or an
+// expression statement.
+func (p *Parser) simpleStmt(items []item) (Stmt, error) {
+	eq := -1
+	for i, it := range items {
+		if it.is("=") {
+			eq = i
+			break
+		}
+	}
+	if eq < 0 {
+		if names, err := p.idents(items, ""); err == nil {
+			return &ParamsStmt{Names: names}, nil
+		}
+		x, err := p.expr(items, "expression")
+		if err != nil {
+			return nil, err
+		}
+		return &ExprStmt{X: x}, nil
+	}
+
+	if eq == 0 || !p.isIdent(items[0]) {
+		return nil, errAt(items[0].pos(), items[0].end(), "expected one or more identifiers to left of assigment operator")
+	}
+	lhs, err := p.idents(items[:eq], "expected another identifier or an assignment symbol '=', got ")
+	if err != nil {
+		return nil, err
+	}
+	s := &AssignStmt{Lhs: lhs, Assign: items[eq].pos()}
+	rhs := items[eq+1:]
+	if len(rhs) == 0 {
+		return nil, errAt(items[eq].pos(), items[eq].end(), "expected an expression to the right of '='")
+	}
+	if rhs[0].group == nil && rhs[0].tok.kind == tokenArrayOpen {
+		s.Rhs, err = p.arrayLit(rhs)
+	} else {
+		s.Rhs, err = p.expr(rhs, "expression")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ifStmt parses 'if cond' and 'if cond stmt', where items starts at 'if'.
+// The condition is the operand after 'if' and the infix operators and
+// operands following it, so the body starts at the first word that is
+// neither, such as 'return' or a function.
+func (p *Parser) ifStmt(items []item) (*IfStmt, error) {
+	s := &IfStmt{If: items[0].pos()}
+	rest := items[1:]
+	if len(rest) == 0 {
+		return nil, errAt(items[0].pos(), items[0].end(), "expected a condition after 'if'")
+	}
+	end := 1
+	for end+1 < len(rest) && isInfix(rest[end]) && !p.isFunc(rest[end+1]) && !isStmtWord(rest[end+1]) {
+		end += 2
+	}
+	var err error
+	if end == 1 {
+		s.Cond, err = p.operand(rest[0])
+	} else {
+		s.Cond, err = p.binary(rest[:end])
+	}
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[end:]
+	if len(rest) == 0 {
+		return s, nil
+	}
+	errThen := errAt(rest[0].pos(), items[len(items)-1].end(), "only a call, 'return', 'break' or 'continue' can follow the condition of a single-line if").withHint("use an if block to run other statements")
+	if rest[0].is("func") {
+		return nil, errThen // parsing it would declare the function
+	}
+	if s.Then, err = p.stmt(rest); err != nil {
+		return nil, err
+	}
+	switch then := s.Then.(type) {
+	case *ExprStmt, *ReturnStmt, *BranchStmt:
+	case *ParamsStmt:
+		// Only a call can follow the condition, so these are a function
+		// the parser does not know of and its arguments.
+		call := &CallExpr{Fun: then.Names[0]}
+		for _, name := range then.Names[1:] {
+			call.Args = append(call.Args, name)
+		}
+		s.Then = &ExprStmt{X: call}
+	default:
+		return nil, errThen
+	}
+	return s, nil
+}
+
+func (p *Parser) forStmt(items []item) (Stmt, error) {
+	if len(items) != 6 || !items[2].is("from") || !items[4].is("to") {
+		return nil, errAt(items[0].pos(), items[len(items)-1].end(), "expected 'for' in the form: for i from a to b")
+	}
+	if !p.isIdent(items[1]) {
+		return nil, errAt(items[1].pos(), items[1].end(), "expected identifier as loop counter, got '"+text(items[1])+"'")
+	}
+	from, err := p.operand(items[3])
+	if err != nil {
+		return nil, err
+	}
+	to, err := p.operand(items[5])
+	if err != nil {
+		return nil, err
+	}
+	return &ForStmt{For: items[0].pos(), Var: ident(items[1]), From: from, To: to}, nil
+}
+
+func (p *Parser) funcStmt(items []item) (Stmt, error) {
+	if len(items) == 1 {
+		return nil, errAt(items[0].pos(), items[0].end(), "expected function name after 'func'")
+	}
+	name := items[1]
+	if name.group != nil || name.tok.kind != tokenWord || !IsIdentifier(name.tok.text) {
+		return nil, errAt(name.pos(), name.end(), "invalid function name: '"+text(name)+"'")
+	}
+	params, err := p.idents(items[2:], "expected identifier in parameter list of '"+name.tok.text+"', got ")
+	if err != nil {
+		return nil, err
+	}
+	// Copied rather than added to, so that copies of p do not share it.
+	declared := map[string]bool{name.tok.text: true}
+	for fn := range p.declared {
+		declared[fn] = true
+	}
+	p.declared = declared
+	return &FuncStmt{Func: items[0].pos(), Name: ident(name), Params: params}, nil
+}
+
+// idents returns items as identifiers, failing with msg and the offending
+// item's text on the first that is not one.
+func (p *Parser) idents(items []item, msg string) ([]*Ident, error) {
+	idents := make([]*Ident, len(items))
+	for i, it := range items {
+		if !p.isIdent(it) {
+			return nil, errAt(it.pos(), it.end(), msg+"'"+text(it)+"'")
+		}
+		idents[i] = ident(it)
+	}
+	return idents, nil
+}
+
+// expr parses items as a single expression: one operand, a call of the one
+// function among them or an infix chain. what names the expression in
+// errors.
+func (p *Parser) expr(items []item, what string) (Expr, error) {
+	if len(items) == 1 && !p.isFunc(items[0]) {
+		return p.operand(items[0])
+	}
+	if p.isInfixChain(items) {
+		return p.binary(items)
+	}
+	fun := -1
+	for i, it := range items {
+		if !p.isFunc(it) {
+			continue
+		}
+		if fun >= 0 {
+			return nil, errAt(items[0].pos(), items[len(items)-1].end(), "ambiguous expression with both '"+items[fun].tok.text+"' and '"+it.tok.text+"'").withHint("wrap each call in its own parentheses")
+		}
+		fun = i
+	}
+	if fun < 0 {
+		// An identifier followed by arguments calls a function the parser
+		// does not know of, which is for the compiler to report if there is
+		// no such function.
+		if !p.isIdent(items[0]) {
+			return nil, errAt(items[0].pos(), items[len(items)-1].end(), "expected a function in "+what)
+		}
+		fun = 0
+	}
+	call := &CallExpr{Fun: ident(items[fun]), FunIndex: fun}
+	for i, it := range items {
+		if i == fun {
+			continue
+		}
+		x, err := p.operand(it)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, x)
+	}
+	return call, nil
+}
+
+// infixPrecedence ranks the binary operators that may be chained without
+// parentheses; higher binds tighter and equal ranks associate to the left.
+var infixPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+// isInfix reports whether it is an infix operator.
+func isInfix(it item) bool {
+	return it.group == nil && it.tok.kind == tokenWord && infixPrecedence[it.tok.text] > 0
+}
+
+// isStmtWord reports whether it is a word that starts the body of a
+// single-line if rather than continuing its condition.
+func isStmtWord(it item) bool {
+	return it.is("return") || it.is("break") || it.is("continue")
+}
+
+// isInfixChain reports whether items alternate operands and infix operators,
+// starting and ending with an operand.
+func (p *Parser) isInfixChain(items []item) bool {
+	if len(items) < 3 || len(items)%2 == 0 {
+		return false
+	}
+	for i, it := range items {
+		if isInfix(it) != (i%2 == 1) || i%2 == 0 && p.isFunc(it) {
+			return false
+		}
+	}
+	return true
+}
+
+// binary parses an infix chain, grouping it by precedence.
+func (p *Parser) binary(items []item) (Expr, error) {
+	var operands []Expr
+	var ops []item
+	reduce := func() {
+		y, x := operands[len(operands)-1], operands[len(operands)-2]
+		op := ops[len(ops)-1]
+		operands = append(operands[:len(operands)-2], &BinaryExpr{X: x, OpPos: op.pos(), Op: op.tok.text, Y: y})
+		ops = ops[:len(ops)-1]
+	}
+	for i, it := range items {
+		if i%2 == 1 {
+			for len(ops) > 0 && infixPrecedence[ops[len(ops)-1].tok.text] >= infixPrecedence[it.tok.text] {
+				reduce()
+			}
+			ops = append(ops, it)
+			continue
+		}
+		x, err := p.operand(it)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, x)
+	}
+	for len(ops) > 0 {
+		reduce()
+	}
+	return operands[0], nil
+}
+
+// arrayLit parses an array literal making up the whole of items.
+func (p *Parser) arrayLit(items []item) (Expr, error) {
+	lit := &ArrayLit{Lbrack: items[0].pos()}
+	closed := false
+	for _, it := range items[1:] {
+		switch {
+		case closed:
+			return nil, errAt(it.pos(), it.end(), "unexpected symbol after array literal: "+text(it))
+		case it.group == nil && it.tok.kind == tokenArrayOpen:
+			return nil, errAt(it.pos(), it.end(), "errant '[' in array declaration")
+		case it.group == nil && it.tok.kind == tokenArrayClose:
+			lit.Rbrack = it.pos()
+			closed = true
+		default:
+			x, err := p.operand(it)
+			if err != nil {
+				return nil, err
+			}
+			lit.Elts = append(lit.Elts, x)
+		}
+	}
+	if !closed {
+		return nil, errAt(items[0].pos(), items[len(items)-1].end(), "expected ']' to close array literal")
+	}
+	return lit, nil
+}
+
+// operand parses a single item that is not a function: a literal, an
+// identifier, a label or a parenthesised expression.
+func (p *Parser) operand(it item) (Expr, error) {
+	if it.group != nil {
+		x, err := p.expr(it.group, "parenthesised expression")
+		if err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Lparen: it.pos(), X: x, Rparen: it.rparen}, nil
+	}
+	tok := it.tok
+	switch tok.kind {
+	case tokenString:
+		return &BasicLit{ValuePos: tok.pos, Kind: String, Value: tok.text}, nil
+	case tokenArrayOpen:
+		return nil, errAt(tok.pos, tok.end(), "array literal must be assigned to a single identifier")
+	case tokenArrayClose:
+		return nil, errAt(tok.pos, tok.end(), "unexpected ']' outside of array literal")
+	}
+	switch word := tok.text; {
+	case p.isFunc(it):
+		return nil, errAt(tok.pos, tok.end(), "expected an operand, got function '"+word+"'").withHint("wrap the call in parentheses")
+	case IsInt(word):
+		return &BasicLit{ValuePos: tok.pos, Kind: Int, Value: word}, nil
+	case IsFloat(word):
+		return &BasicLit{ValuePos: tok.pos, Kind: Float, Value: word}, nil
+	case word == "true" || word == "false":
+		return &BasicLit{ValuePos: tok.pos, Kind: Bool, Value: word}, nil
+	case IsLabel(word):
+		return &Label{TildePos: tok.pos, Name: word[1:]}, nil
+	case IsIdentifier(word):
+		return ident(it), nil
+	}
+	return &BadExpr{From: tok.pos, Text: tok.text}, nil
+}
+
+// isFunc reports whether it is the name of a function.
+func (p *Parser) isFunc(it item) bool {
+	if it.group != nil || it.tok.kind != tokenWord {
+		return false
+	}
+	name := it.tok.text
+	return p.declared[name] || p.Funcs != nil && p.Funcs(name)
+}
+
+// isIdent reports whether it is an identifier that names no function.
+func (p *Parser) isIdent(it item) bool {
+	return it.group == nil && it.tok.kind == tokenWord && IsIdentifier(it.tok.text) && !p.isFunc(it)
+}
+
+func ident(it item) *Ident {
+	return &Ident{NamePos: it.pos(), Name: it.tok.text}
+}
+
+// text returns the source text of a word or the brackets of a group.
+func text(it item) string {
+	if it.group != nil {
+		return "(...)"
+	}
+	return it.tok.text
+}
+
+func errAt(pos, end Pos, msg string) *Error {
+	return &Error{Pos: pos, End: end, Msg: msg}
+}
+
+func (e *Error) withHint(hint string) *Error {
+	e.Hint = hint
+	return e
+}
+
+func setComment(stmt Stmt, c *Comment) {
+	switch s := stmt.(type) {
+	case *ParamsStmt:
+		s.Comment = c
+	case *OutStmt:
+		s.Comment = c
+	case *AssignStmt:
+		s.Comment = c
+	case *ExprStmt:
+		s.Comment = c
+	case *LabelStmt:
+		s.Comment = c
+	case *IfStmt:
+		s.Comment = c
+	case *ElseStmt:
+		s.Comment = c
+	case *WhileStmt:
+		s.Comment = c
+	case *ForStmt:
+		s.Comment = c
+	case *FuncStmt:
+		s.Comment = c
+	case *ReturnStmt:
+		s.Comment = c
+	case *BranchStmt:
+		s.Comment = c
+	case *EndStmt:
+		s.Comment = c
+	}
+}
+
+// commentOf returns the comment ending the line of stmt.
+func commentOf(stmt Stmt) *Comment {
+	switch s := stmt.(type) {
+	case *ParamsStmt:
+		return s.Comment
+	case *OutStmt:
+		return s.Comment
+	case *AssignStmt:
+		return s.Comment
+	case *ExprStmt:
+		return s.Comment
+	case *LabelStmt:
+		return s.Comment
+	case *IfStmt:
+		return s.Comment
+	case *ElseStmt:
+		return s.Comment
+	case *WhileStmt:
+		return s.Comment
+	case *ForStmt:
+		return s.Comment
+	case *FuncStmt:
+		return s.Comment
+	case *ReturnStmt:
+		return s.Comment
+	case *BranchStmt:
+		return s.Comment
+	case *EndStmt:
+		return s.Comment
+	}
+	return nil
+}
+
+var keywords = map[string]bool{
+	"break":    true,
+	"continue": true,
+	"else":     true,
+	"end":      true,
+	"for":      true,
+	"func":     true,
+	"if":       true,
+	"out":      true,
+	"return":   true,
+	"while":    true,
+}
+
+// IsKeyword reports whether word is reserved for statements.
+func IsKeyword(word string) bool {
+	return keywords[word]
+}
+
+// IsIdentifier reports whether word can name a variable, parameter or
+// function: a lowercase letter followed by letters and digits, other than
+// a keyword or bool. Whether it names a function already is up to the
+// caller.
+func IsIdentifier(word string) bool {
+	if word == "" || keywords[word] || word == "true" || word == "false" {
+		return false
+	}
+	for i, r := range word {
+		if i == 0 {
+			if !unicode.IsLetter(r) || !unicode.IsLower(r) {
+				return false
+			}
+		} else if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsLabel reports whether word is a label: '~' followed by an identifier.
+func IsLabel(word string) bool {
+	return len(word) > 1 && word[0] == '~' && IsIdentifier(word[1:])
+}
+
+// IsInt reports whether word is an int literal.
+func IsInt(word string) bool {
+	_, err := strconv.Atoi(word)
+	return err == nil
+}
+
+// IsFloat reports whether word is a float literal: a decimal with a
+// fractional part, such as 1.5 or -0.25, so that ints and words like "Inf"
+// are never taken for floats.
+func IsFloat(word string) bool {
+	if !strings.Contains(word, ".") {
+		return false
+	}
+	_, err := strconv.ParseFloat(word, 64)
+	return err == nil
+}
@@ -0,0 +1,216 @@
+package ast
+
+import (
+	"io"
+	"strings"
+)
+
+// Fprint writes node to w as source. A File is printed in canonical form:
+// one statement per line, indented by two spaces per open block, with runs
+// of blank lines kept as one. A statement is printed without indentation or
+// line break.
+//
+// Printing what ParseFile returns and parsing that again gives the same
+// tree, but for positions.
+func Fprint(w io.Writer, node Node) error {
+	var b strings.Builder
+	switch n := node.(type) {
+	case *File:
+		printFile(&b, n)
+	case Stmt:
+		printStmt(&b, n)
+	case Expr:
+		printExpr(&b, n)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func printFile(b *strings.Builder, f *File) {
+	depth := 0
+	for i, stmt := range f.Stmts {
+		if i > 0 && stmt.Pos().Line > f.Stmts[i-1].Pos().Line+1 {
+			b.WriteByte('\n')
+		}
+		indent := depth
+		switch s := stmt.(type) {
+		case *EndStmt:
+			depth--
+			indent = depth
+		case *ElseStmt:
+			indent--
+		case *IfStmt:
+			if s.Then == nil {
+				depth++
+			}
+		case *WhileStmt, *ForStmt, *FuncStmt:
+			depth++
+		}
+		if depth < 0 {
+			depth = 0
+		}
+		for ; indent > 0; indent-- {
+			b.WriteString("  ")
+		}
+		printStmt(b, stmt)
+		b.WriteByte('\n')
+	}
+}
+
+func printStmt(b *strings.Builder, stmt Stmt) {
+	printBody(b, stmt)
+	if c := commentOf(stmt); c != nil {
+		b.WriteString(" " + c.Text)
+	}
+}
+
+// printBody prints stmt without the comment ending its line.
+func printBody(b *strings.Builder, stmt Stmt) {
+	switch s := stmt.(type) {
+	case *CommentStmt:
+		b.WriteString(s.Comment.Text)
+	case *ParamsStmt:
+		printIdents(b, s.Names)
+	case *OutStmt:
+		b.WriteString("out")
+		if len(s.Names) > 0 {
+			b.WriteByte(' ')
+			printIdents(b, s.Names)
+		}
+	case *AssignStmt:
+		printIdents(b, s.Lhs)
+		b.WriteString(" = ")
+		printExpr(b, s.Rhs)
+	case *ExprStmt:
+		printExpr(b, s.X)
+	case *LabelStmt:
+		printExpr(b, s.Label)
+	case *IfStmt:
+		printIf(b, s)
+	case *ElseStmt:
+		b.WriteString("else")
+		if s.If != nil {
+			b.WriteByte(' ')
+			printIf(b, s.If)
+		}
+	case *WhileStmt:
+		b.WriteString("while ")
+		printExpr(b, s.Cond)
+	case *ForStmt:
+		b.WriteString("for " + s.Var.Name + " from ")
+		printOperand(b, s.From)
+		b.WriteString(" to ")
+		printOperand(b, s.To)
+	case *FuncStmt:
+		b.WriteString("func " + s.Name.Name)
+		for _, param := range s.Params {
+			b.WriteString(" " + param.Name)
+		}
+	case *ReturnStmt:
+		b.WriteString("return")
+		for _, result := range s.Results {
+			b.WriteByte(' ')
+			printOperand(b, result)
+		}
+	case *BranchStmt:
+		b.WriteString(s.Tok)
+	case *EndStmt:
+		b.WriteString("end")
+	}
+}
+
+func printIf(b *strings.Builder, s *IfStmt) {
+	b.WriteString("if ")
+	if _, ok := s.Cond.(*BinaryExpr); ok {
+		printExpr(b, s.Cond)
+	} else {
+		printOperand(b, s.Cond)
+	}
+	if s.Then != nil {
+		b.WriteByte(' ')
+		printBody(b, s.Then)
+	}
+}
+
+func printIdents(b *strings.Builder, idents []*Ident) {
+	for i, id := range idents {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(id.Name)
+	}
+}
+
+func printExpr(b *strings.Builder, x Expr) {
+	switch x := x.(type) {
+	case *Ident:
+		b.WriteString(x.Name)
+	case *BasicLit:
+		b.WriteString(x.Value)
+	case *Label:
+		b.WriteString("~" + x.Name)
+	case *BadExpr:
+		b.WriteString(x.Text)
+	case *ParenExpr:
+		b.WriteByte('(')
+		printExpr(b, x.X)
+		b.WriteByte(')')
+	case *CallExpr:
+		for i, arg := range x.Args {
+			if i == x.FunIndex {
+				b.WriteString(x.Fun.Name + " ")
+			}
+			printOperand(b, arg)
+			if i < len(x.Args)-1 {
+				b.WriteByte(' ')
+			}
+		}
+		if x.FunIndex >= len(x.Args) {
+			if len(x.Args) > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(x.Fun.Name)
+		}
+	case *BinaryExpr:
+		prec := infixPrecedence[x.Op]
+		printSide(b, x.X, prec, false)
+		b.WriteString(" " + x.Op + " ")
+		printSide(b, x.Y, prec, true)
+	case *ArrayLit:
+		b.WriteByte('[')
+		for i, elt := range x.Elts {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			printOperand(b, elt)
+		}
+		b.WriteByte(']')
+	}
+}
+
+// printOperand prints x where only an operand may go, parenthesising it if
+// it is a call or infix expression.
+func printOperand(b *strings.Builder, x Expr) {
+	switch x.(type) {
+	case *CallExpr, *BinaryExpr:
+		b.WriteByte('(')
+		printExpr(b, x)
+		b.WriteByte(')')
+	default:
+		printExpr(b, x)
+	}
+}
+
+// printSide prints an operand of an infix operator of precedence prec,
+// parenthesising an infix expression that would otherwise group
+// differently. right tells whether x is the right operand, which groups
+// differently at equal precedence too.
+func printSide(b *strings.Builder, x Expr, prec int, right bool) {
+	if bin, ok := x.(*BinaryExpr); ok {
+		if p := infixPrecedence[bin.Op]; p > prec || p == prec && !right {
+			printExpr(b, x)
+			return
+		}
+	}
+	printOperand(b, x)
+}
@@ -0,0 +1,157 @@
+package ast
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func format(t *testing.T, src string) string {
+	t.Helper()
+	f, err := ParseFile(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := Fprint(&b, f); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// trimLines drops the indentation and trailing spaces of every line of src,
+// and its final newline.
+func trimLines(src string) string {
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// The scripts are canonical but for their indentation and final newline, so
+// printing one gives it back with those fixed.
+func TestPrintRoundTrip(t *testing.T) {
+	var paths []string
+	for _, dir := range []string{"../examples", "../bench"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.ez"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no scripts found")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := format(t, string(src))
+			if trimLines(got) != trimLines(string(src)) {
+				t.Errorf("printed\n%s\nwant\n%s", got, src)
+			}
+			if again := format(t, got); again != got {
+				t.Errorf("printing again gave\n%s", again)
+			}
+		})
+	}
+}
+
+func TestPrintCanonical(t *testing.T) {
+	const src = `   # header comment
+a   =   2    # two
+b = ( a + 3 )
+c = (a + b)*(b - 1)
+
+
+
+func  sq  n
+r = n * n
+   return r   # result
+end
+arr = [ 1  2 (sq 3)]
+if a < b
+print 'lt ( # not a comment'
+   else   if (a == b)
+ print 'eq'
+else
+print (str c)
+end
+if true print (len arr)
+if a + 1 >   b   print a
+x = a + b * c - (1 + 2) / 3
+`
+	const want = `# header comment
+a = 2 # two
+b = (a + 3)
+c = (a + b) * (b - 1)
+
+func sq n
+  r = n * n
+  return r # result
+end
+arr = [1 2 (sq 3)]
+if a < b
+  print 'lt ( # not a comment'
+else if (a == b)
+  print 'eq'
+else
+  print (str c)
+end
+if true print (len arr)
+if a + 1 > b print a
+x = a + b * c - (1 + 2) / 3
+`
+	got := format(t, src)
+	if got != want {
+		t.Errorf("printed\n%s\nwant\n%s", got, want)
+	}
+	if again := format(t, got); again != got {
+		t.Errorf("printing again gave\n%s", again)
+	}
+}
+
+// Functions the script does not declare are unknown to a parser without
+// Funcs, which takes an identifier followed by arguments for a call of one.
+func TestParseUnknownFuncs(t *testing.T) {
+	const src = `ok = true
+x = shout 'hi'
+shout 'a' 2
+if ok log x
+if ok shout 'z'
+y = (shout 'a') + 'b'
+`
+	f, err := ParseFile(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := []string{"", "shout", "shout", "log", "shout", "shout"}
+	for i, stmt := range f.Stmts {
+		var x Expr
+		switch s := stmt.(type) {
+		case *AssignStmt:
+			x = s.Rhs
+		case *ExprStmt:
+			x = s.X
+		case *IfStmt:
+			x = s.Then.(*ExprStmt).X
+		}
+		if b, ok := x.(*BinaryExpr); ok {
+			x = b.X.(*ParenExpr).X
+		}
+		var got string
+		if call, ok := x.(*CallExpr); ok {
+			got = call.Fun.Name
+		}
+		if got != calls[i] {
+			t.Errorf("line %d calls %q, want %q", i+1, got, calls[i])
+		}
+	}
+	if got := format(t, src); got != src {
+		t.Errorf("printed\n%s\nwant\n%s", got, src)
+	}
+}
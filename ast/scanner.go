@@ -0,0 +1,144 @@
+package ast
+
+import (
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenWord       tokenKind = iota // identifier, keyword, function, operator, number, bool or label
+	tokenString                      // single-quoted string, escapes undecoded
+	tokenOpen                        // (
+	tokenClose                       // )
+	tokenArrayOpen                   // [
+	tokenArrayClose                  // ]
+	tokenComment                     // from '#' to the end of the line
+)
+
+// token is a lexeme of a source line. text is as written, so strings keep
+// their quotes and escapes.
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+}
+
+func (t token) end() Pos {
+	return t.pos.add(len(t.text))
+}
+
+// scan splits a line into tokens. Tokens are separated by whitespace or by
+// brackets and parentheses, which are tokens of their own; a string runs to
+// its closing quote whatever it contains, and a comment from a '#' at the
+// start of a token to the end of the line.
+func scan(line int, text string) ([]token, error) {
+	var tokens []token
+	add := func(kind tokenKind, start, end int) {
+		tokens = append(tokens, token{kind: kind, text: text[start:end], pos: Pos{Line: line, Col: start + 1}})
+	}
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '#':
+			add(tokenComment, i, len(text))
+			return tokens, nil
+		case r == '(':
+			add(tokenOpen, i, i+1)
+			i++
+		case r == ')':
+			add(tokenClose, i, i+1)
+			i++
+		case r == '[':
+			add(tokenArrayOpen, i, i+1)
+			i++
+		case r == ']':
+			add(tokenArrayClose, i, i+1)
+			i++
+		case r == '\'':
+			end, err := scanString(line, text, i)
+			if err != nil {
+				return nil, err
+			}
+			add(tokenString, i, end)
+			i = end
+		default:
+			start := i
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if unicode.IsSpace(r) || isDelimiter(r) {
+					break
+				}
+				i += size
+			}
+			add(tokenWord, start, i)
+		}
+	}
+	return tokens, nil
+}
+
+// scanString returns the offset just past the closing quote of the string
+// starting at text[start], checking that each of its escapes is valid.
+func scanString(line int, text string, start int) (int, error) {
+	for i := start + 1; i < len(text); {
+		switch text[i] {
+		case '\'':
+			return i + 1, nil
+		case '\\':
+			_, _, tail, err := strconv.UnquoteChar(text[i:], '\'')
+			if err != nil {
+				end := i + 2
+				if end > len(text) {
+					end = len(text)
+				}
+				return 0, &Error{
+					Pos:  Pos{Line: line, Col: i + 1},
+					End:  Pos{Line: line, Col: end + 1},
+					Msg:  "invalid escape sequence in string",
+					Hint: `valid escapes are \n, \t, \r, \\, \', \xFF, \uFFFF and \UFFFFFFFF`,
+				}
+			}
+			i = len(text) - len(tail)
+		default:
+			i++
+		}
+	}
+	return 0, &Error{
+		Pos:  Pos{Line: line, Col: start + 1},
+		End:  Pos{Line: line, Col: len(text) + 1},
+		Msg:  "unterminated string",
+		Hint: `close the string with ' on the same line, using \n for line breaks`,
+	}
+}
+
+func isDelimiter(r rune) bool {
+	switch r {
+	case '(', ')', '[', ']', '\'':
+		return true
+	}
+	return false
+}
+
+// Unquote decodes the string literal raw, as written in a BasicLit of kind
+// String. It panics if raw was not accepted by the parser.
+func Unquote(raw string) string {
+	s := raw[1 : len(raw)-1]
+	buf := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, '\'')
+		if err != nil {
+			panic("failed to decode string " + raw + " even though it was parsed: " + err.Error())
+		}
+		if r < utf8.RuneSelf || !multibyte {
+			buf = append(buf, byte(r))
+		} else {
+			buf = utf8.AppendRune(buf, r)
+		}
+		s = tail
+	}
+	return string(buf)
+}
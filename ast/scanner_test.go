@@ -0,0 +1,61 @@
+package ast
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestScanString(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`x = 'a\tb'`, "a\tb"},
+		{`x = 'it\'s'`, "it's"},
+		{`x = 'caf\u00e9'`, "café"},
+		{`x = 'café'`, "café"},
+		{`x = '\x41\n\\'`, "A\n\\"},
+		{`x = 'a # b'`, "a # b"},
+		{`x = ''`, ""},
+	}
+	for _, test := range tests {
+		tokens, err := scan(1, test.line)
+		if err != nil {
+			t.Errorf("%s: %v", test.line, err)
+			continue
+		}
+		last := tokens[len(tokens)-1]
+		if len(tokens) != 3 || last.kind != tokenString {
+			t.Errorf("%s: scanned %+v", test.line, tokens)
+			continue
+		}
+		if got := Unquote(last.text); got != test.want {
+			t.Errorf("%s: decoded %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestScanStringErrors(t *testing.T) {
+	tests := []struct {
+		line     string
+		msg      string
+		col, end int
+	}{
+		{`x = 'a\qb'`, "invalid escape sequence in string", 7, 9},
+		{`x = '\u00'`, "invalid escape sequence in string", 6, 8},
+		{`x = 'abc\`, "invalid escape sequence in string", 9, 10},
+		{`x = 'abc`, "unterminated string", 5, 9},
+		{`x = 'it\'`, "unterminated string", 5, 10},
+	}
+	for _, test := range tests {
+		_, err := scan(1, test.line)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: error %v, want an *Error", test.line, err)
+			continue
+		}
+		if e.Msg != test.msg || e.Pos.Col != test.col || e.End.Col != test.end {
+			t.Errorf("%s: %q at %d-%d, want %q at %d-%d", test.line, e.Msg, e.Pos.Col, e.End.Col, test.msg, test.col, test.end)
+		}
+	}
+}
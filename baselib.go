@@ -1,148 +1,417 @@
 package ez
 
 type Func struct {
-	In   []baseType
-	Out  []baseType
-	addr int
+	In      []Type
+	Out     []Type
+	addr    int
+	fnIndex int // index into Bytecode.Funcs when addr is iopCall
 }
 
+// funcRef is the operand type of an index into Bytecode.Funcs.
+const funcRef Type = -2
+
+// hostRef is the operand type of an index into Bytecode.Hosts.
+const hostRef Type = -3
+
+// jumpPos is the operand type of a raw position in OpAddrs, as opposed to
+// Addr which is an Ints slot holding a position.
+const jumpPos Type = -1
+
+type opcode struct {
+	name string
+	args []Type // In followed by Out, in encoding order
+	outs int    // number of trailing args written by the op
+}
+
+var opcodes = buildOpcodes()
+
 const (
 	iopIntCopy = iota
 	iopStrCopy
 	iopBoolCopy
 )
 
+const (
+	iopIntArrCopy = iota + 24
+	iopStrArrCopy
+	iopBoolArrCopy
+	iopIntArrClear
+	iopStrArrClear
+	iopBoolArrClear
+)
+
+const (
+	iopCall = iota + 45
+	iopReturn
+	iopMissingReturn
+	iopFloatCopy
+)
+
+const iopHostCall = 67
+
 var baselib = map[string][]Func{
 	"!=": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Bool},
+			In:   []Type{Int, Int},
+			Out:  []Type{Bool},
 			addr: 3,
 		},
 		{
-			In:   []baseType{Str, Str},
-			Out:  []baseType{Bool},
+			In:   []Type{Str, Str},
+			Out:  []Type{Bool},
 			addr: 4,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Bool},
+			addr: 49,
+		},
 	},
 	"%": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Int},
+			In:   []Type{Int, Int},
+			Out:  []Type{Int},
 			addr: 5,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Float},
+			addr: 50,
+		},
 	},
 	"&&": {
 		{
-			In:   []baseType{Bool, Bool},
-			Out:  []baseType{Bool},
+			In:   []Type{Bool, Bool},
+			Out:  []Type{Bool},
 			addr: 6,
 		},
 	},
 	"*": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Int},
+			In:   []Type{Int, Int},
+			Out:  []Type{Int},
 			addr: 7,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Float},
+			addr: 51,
+		},
 	},
 	"+": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Int},
+			In:   []Type{Int, Int},
+			Out:  []Type{Int},
 			addr: 8,
 		},
 		{
-			In:   []baseType{Str, Str},
-			Out:  []baseType{Str},
+			In:   []Type{Str, Str},
+			Out:  []Type{Str},
 			addr: 9,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Float},
+			addr: 52,
+		},
 	},
 	"-": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Int},
+			In:   []Type{Int, Int},
+			Out:  []Type{Int},
 			addr: 10,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Float},
+			addr: 53,
+		},
 	},
 	"/": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Int},
+			In:   []Type{Int, Int},
+			Out:  []Type{Int},
 			addr: 11,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Float},
+			addr: 54,
+		},
 	},
 	"<": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Bool},
+			In:   []Type{Int, Int},
+			Out:  []Type{Bool},
 			addr: 12,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Bool},
+			addr: 55,
+		},
 	},
 	"<=": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Bool},
+			In:   []Type{Int, Int},
+			Out:  []Type{Bool},
 			addr: 13,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Bool},
+			addr: 56,
+		},
 	},
 	"==": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Bool},
+			In:   []Type{Int, Int},
+			Out:  []Type{Bool},
 			addr: 14,
 		},
 		{
-			In:   []baseType{Str, Str},
-			Out:  []baseType{Bool},
+			In:   []Type{Str, Str},
+			Out:  []Type{Bool},
 			addr: 15,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Bool},
+			addr: 57,
+		},
 	},
 	">": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Bool},
+			In:   []Type{Int, Int},
+			Out:  []Type{Bool},
 			addr: 16,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Bool},
+			addr: 58,
+		},
 	},
 	">=": {
 		{
-			In:   []baseType{Int, Int},
-			Out:  []baseType{Bool},
+			In:   []Type{Int, Int},
+			Out:  []Type{Bool},
 			addr: 17,
 		},
+		{
+			In:   []Type{Float, Float},
+			Out:  []Type{Bool},
+			addr: 59,
+		},
+	},
+	"append": {
+		{
+			In:   []Type{ArrInt, Int},
+			Out:  []Type{ArrInt},
+			addr: 30,
+		},
+		{
+			In:   []Type{ArrStr, Str},
+			Out:  []Type{ArrStr},
+			addr: 31,
+		},
+		{
+			In:   []Type{ArrBool, Bool},
+			Out:  []Type{ArrBool},
+			addr: 32,
+		},
+	},
+	"float": {
+		{
+			In:   []Type{Int},
+			Out:  []Type{Float},
+			addr: 61,
+		},
+		{
+			In:   []Type{Str},
+			Out:  []Type{Float},
+			addr: 62,
+		},
+	},
+	"get": {
+		{
+			In:   []Type{ArrInt, Int},
+			Out:  []Type{Int},
+			addr: 33,
+		},
+		{
+			In:   []Type{ArrStr, Int},
+			Out:  []Type{Str},
+			addr: 34,
+		},
+		{
+			In:   []Type{ArrBool, Int},
+			Out:  []Type{Bool},
+			addr: 35,
+		},
 	},
 	"goto": {
 		{
-			In:   []baseType{Addr},
+			In:   []Type{Addr},
 			addr: 18,
 		},
 	},
 	"if": {
 		{
-			In:   []baseType{Bool},
+			In:   []Type{Bool},
 			addr: 19,
 		},
 	},
+	"int": {
+		{
+			In:   []Type{Float},
+			Out:  []Type{Int},
+			addr: 63,
+		},
+		{
+			In:   []Type{Str},
+			Out:  []Type{Int},
+			addr: 64,
+		},
+	},
+	"len": {
+		{
+			In:   []Type{ArrInt},
+			Out:  []Type{Int},
+			addr: 36,
+		},
+		{
+			In:   []Type{ArrStr},
+			Out:  []Type{Int},
+			addr: 37,
+		},
+		{
+			In:   []Type{ArrBool},
+			Out:  []Type{Int},
+			addr: 38,
+		},
+	},
 	"print": {
 		{
-			In:   []baseType{Str},
+			In:   []Type{Str},
 			addr: 20,
 		},
 		{
-			In:   []baseType{Int},
+			In:   []Type{Int},
 			addr: 21,
 		},
 		{
-			In:   []baseType{Bool},
+			In:   []Type{Bool},
 			addr: 22,
 		},
+		{
+			In:   []Type{ArrInt},
+			addr: 42,
+		},
+		{
+			In:   []Type{ArrStr},
+			addr: 43,
+		},
+		{
+			In:   []Type{ArrBool},
+			addr: 44,
+		},
+		{
+			In:   []Type{Float},
+			addr: 60,
+		},
+	},
+	"set": {
+		{
+			In:   []Type{ArrInt, Int, Int},
+			addr: 39,
+		},
+		{
+			In:   []Type{ArrStr, Int, Str},
+			addr: 40,
+		},
+		{
+			In:   []Type{ArrBool, Int, Bool},
+			addr: 41,
+		},
+	},
+	"str": {
+		{
+			In:   []Type{Int},
+			Out:  []Type{Str},
+			addr: 65,
+		},
+		{
+			In:   []Type{Float},
+			Out:  []Type{Str},
+			addr: 66,
+		},
+	},
+	"yield": {
+		{
+			addr: 68,
+		},
 	},
 	"||": {
 		{
-			In:   []baseType{Bool, Bool},
-			Out:  []baseType{Bool},
+			In:   []Type{Bool, Bool},
+			Out:  []Type{Bool},
 			addr: 23,
 		},
 	},
 }
+
+func baselibAddr(name string, in ...Type) int {
+	for _, fun := range baselib[name] {
+		if len(fun.In) < len(in) {
+			continue
+		}
+		match := true
+		for i, typ := range in {
+			if fun.In[i] != typ {
+				match = false
+				break
+			}
+		}
+		if match {
+			return fun.addr
+		}
+	}
+	panic("no baselib function '" + name + "' for given types")
+}
+
+func buildOpcodes() []opcode {
+	var ops []opcode
+	add := func(addr int, name string, outs int, args ...Type) {
+		for len(ops) <= addr {
+			ops = append(ops, opcode{})
+		}
+		ops[addr] = opcode{name: name, args: args, outs: outs}
+	}
+	add(iopIntCopy, "copy", 1, Int, Int)
+	add(iopStrCopy, "copy", 1, Str, Str)
+	add(iopBoolCopy, "copy", 1, Bool, Bool)
+	add(iopFloatCopy, "copy", 1, Float, Float)
+	add(iopIntArrCopy, "copy", 1, ArrInt, ArrInt)
+	add(iopStrArrCopy, "copy", 1, ArrStr, ArrStr)
+	add(iopBoolArrCopy, "copy", 1, ArrBool, ArrBool)
+	add(iopIntArrClear, "clear", 1, ArrInt)
+	add(iopStrArrClear, "clear", 1, ArrStr)
+	add(iopBoolArrClear, "clear", 1, ArrBool)
+	// The operands following funcRef depend on the function called and are
+	// looked up with Bytecode.opArgs.
+	add(iopCall, "call", 0, funcRef)
+	add(iopReturn, "return", 0, funcRef)
+	add(iopMissingReturn, "missingreturn", 0, funcRef)
+	add(iopHostCall, "hostcall", 0, hostRef)
+	for name, funcs := range baselib {
+		for _, fun := range funcs {
+			args := append(append([]Type{}, fun.In...), fun.Out...)
+			if name == "if" {
+				args = append(args, jumpPos)
+			}
+			add(fun.addr, name, len(fun.Out), args...)
+		}
+	}
+	return ops
+}
@@ -0,0 +1,54 @@
+package ez
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func BenchmarkArrays(b *testing.B)  { benchScript(b, "arrays") }
+func BenchmarkFib(b *testing.B)     { benchScript(b, "fib") }
+func BenchmarkFloats(b *testing.B)  { benchScript(b, "floats") }
+func BenchmarkLoop(b *testing.B)    { benchScript(b, "loop") }
+func BenchmarkStrings(b *testing.B) { benchScript(b, "strings") }
+
+// benchScript runs bench/name.ez as compiled and as optimized, compiling it
+// once and resetting one machine between runs.
+func benchScript(b *testing.B, name string) {
+	path := filepath.Join("bench", name+".ez")
+	for _, optimized := range []bool{false, true} {
+		prog := compileFile(b, path)
+		sub := "compiled"
+		if optimized {
+			sub = "optimized"
+			if err := prog.Optimize(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(sub, func(b *testing.B) {
+			m := prog.NewMachine()
+			opts := Options{Output: io.Discard}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.Reset()
+				if err := m.Run(context.Background(), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExec measures what running a program costs beyond its ops, with
+// a new machine each run.
+func BenchmarkExec(b *testing.B) {
+	prog := compileFile(b, filepath.Join("examples", "params.ez"))
+	inputs := scriptInputs["params.ez"]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Exec(inputs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package ez
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// The .ezc layout is the magic, the format version as a uvarint, the ABI
+// hash as 8 little-endian bytes, then a sequence of sections. Each section is
+// a tag byte and a uvarint payload length. Integers in payloads are varints
+// and every list is prefixed with its uvarint length. Every section below
+// sectionHosts is required. Sections tagged at or above optionalSection may
+// be skipped by readers that do not know them; any other unknown tag makes
+// the file incompatible.
+const (
+	ezcMagic         = "EZC\x00"
+	ezcFormatVersion = 1
+	optionalSection  = 128
+)
+
+const (
+	sectionOps byte = iota + 1
+	sectionInts
+	sectionStrs
+	sectionBools
+	sectionIntArrs
+	sectionStrArrs
+	sectionBoolArrs
+	sectionFuncs
+	sectionFloats
+	sectionHosts
+)
+
+const (
+	sectionLines byte = iota + optionalSection
+	sectionNames
+)
+
+var ErrIncompatibleBytecode = errors.New("incompatible bytecode")
+
+// abiHash identifies the opcode numbering and operand layout that encoded
+// bytecode relies on. Any change to baselib or the internal ops changes it.
+var abiHash = computeABIHash()
+
+func computeABIHash() uint64 {
+	h := fnv.New64a()
+	for i, op := range opcodes {
+		h.Write([]byte(strconv.Itoa(i) + ":" + op.name + "("))
+		for _, typ := range op.args {
+			h.Write([]byte(strconv.Itoa(int(typ)) + ","))
+		}
+		h.Write([]byte(")"))
+	}
+	return h.Sum64()
+}
+
+func (bc Bytecode) MarshalBinary() ([]byte, error) {
+	buf := []byte(ezcMagic)
+	buf = binary.AppendUvarint(buf, ezcFormatVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, abiHash)
+
+	var e encoder
+	e.ints(bc.OpAddrs)
+	buf = appendSection(buf, sectionOps, e.flush())
+	buf = appendPools(buf, &bc)
+	e.uint(len(bc.Funcs))
+	for _, fn := range bc.Funcs {
+		e.str(fn.Name)
+		e.int(fn.Entry)
+		e.types(fn.In)
+		e.types(fn.Out)
+		e.ints(fn.Params)
+		for _, r := range fn.Frame.ranges() {
+			e.int(r.Start)
+			e.int(r.End)
+		}
+	}
+	buf = appendSection(buf, sectionFuncs, e.flush())
+	if len(bc.Hosts) > 0 {
+		e.uint(len(bc.Hosts))
+		for _, host := range bc.Hosts {
+			e.str(host.Name)
+			e.types(host.In)
+			e.types(host.Out)
+		}
+		buf = appendSection(buf, sectionHosts, e.flush())
+	}
+	if len(bc.Lines) > 0 {
+		e.uint(len(bc.Lines))
+		for _, mark := range bc.Lines {
+			e.int(mark.Pos)
+			e.uint(int(mark.Line))
+		}
+		buf = appendSection(buf, sectionLines, e.flush())
+	}
+	if len(bc.Names) > 0 {
+		e.uint(len(bc.Names))
+		for _, name := range bc.Names {
+			e.int(int(name.Type))
+			e.uint(name.Slot)
+			e.str(name.Name)
+			e.int(name.Func)
+		}
+		buf = appendSection(buf, sectionNames, e.flush())
+	}
+	return buf, nil
+}
+
+func (bc *Bytecode) UnmarshalBinary(data []byte) error {
+	if len(data) < len(ezcMagic) || string(data[:len(ezcMagic)]) != ezcMagic {
+		return errors.New("not an ezc file: bad magic number")
+	}
+	d := decoder{buf: data[len(ezcMagic):]}
+	if version := d.uint(); d.err == nil && version != ezcFormatVersion {
+		return fmt.Errorf("%w: ezc format version %d is not supported", ErrIncompatibleBytecode, version)
+	}
+	if len(d.buf) < 8 {
+		return errTruncated
+	}
+	if hash := binary.LittleEndian.Uint64(d.buf); hash != abiHash {
+		return fmt.Errorf("%w: ezc file was compiled against a different opcode table", ErrIncompatibleBytecode)
+	}
+	d.buf = d.buf[8:]
+
+	var out Bytecode
+	seen := map[byte]bool{}
+	for d.err == nil && len(d.buf) > 0 {
+		tag := d.buf[0]
+		d.buf = d.buf[1:]
+		size := d.uint()
+		if d.err != nil || size > len(d.buf) {
+			return errTruncated
+		}
+		s := decoder{buf: d.buf[:size]}
+		d.buf = d.buf[size:]
+		switch tag {
+		case sectionOps:
+			out.OpAddrs = s.ints()
+		case sectionFuncs:
+			out.Funcs = make([]FuncInfo, s.count())
+			for i := range out.Funcs {
+				fn := &out.Funcs[i]
+				fn.Name = s.str()
+				fn.Entry = s.int()
+				fn.In = s.types()
+				fn.Out = s.types()
+				fn.Params = s.ints()
+				for _, r := range fn.Frame.rangePtrs() {
+					r.Start = s.int()
+					r.End = s.int()
+				}
+			}
+		case sectionHosts:
+			out.Hosts = make([]HostInfo, s.count())
+			for i := range out.Hosts {
+				out.Hosts[i].Name = s.str()
+				out.Hosts[i].In = s.types()
+				out.Hosts[i].Out = s.types()
+			}
+		case sectionLines:
+			out.Lines = make([]LineMark, s.count())
+			for i := range out.Lines {
+				out.Lines[i].Pos = s.int()
+				out.Lines[i].Line = uint16(s.uint())
+			}
+		case sectionNames:
+			out.Names = make([]SlotName, s.count())
+			for i := range out.Names {
+				out.Names[i].Type = Type(s.int())
+				out.Names[i].Slot = s.uint()
+				out.Names[i].Name = s.str()
+				out.Names[i].Func = s.int()
+			}
+		default:
+			if !decodePool(tag, &s, &out) && tag < optionalSection {
+				return fmt.Errorf("%w: unknown ezc section %d", ErrIncompatibleBytecode, tag)
+			}
+		}
+		if s.err != nil {
+			return s.err
+		}
+		seen[tag] = true
+	}
+	if d.err != nil {
+		return d.err
+	}
+	for _, tag := range []byte{sectionOps, sectionInts, sectionStrs, sectionBools, sectionIntArrs, sectionStrArrs, sectionBoolArrs, sectionFuncs, sectionFloats} {
+		if !seen[tag] {
+			return errTruncated
+		}
+	}
+	*bc = out
+	return nil
+}
+
+// appendPools appends a section for each slot pool of bc.
+func appendPools(buf []byte, bc *Bytecode) []byte {
+	var e encoder
+	e.ints(bc.Ints)
+	buf = appendSection(buf, sectionInts, e.flush())
+	e.strs(bc.Strs)
+	buf = appendSection(buf, sectionStrs, e.flush())
+	e.bools(bc.Bools)
+	buf = appendSection(buf, sectionBools, e.flush())
+	e.uint(len(bc.IntArrs))
+	for _, arr := range bc.IntArrs {
+		e.ints(arr)
+	}
+	buf = appendSection(buf, sectionIntArrs, e.flush())
+	e.uint(len(bc.StrArrs))
+	for _, arr := range bc.StrArrs {
+		e.strs(arr)
+	}
+	buf = appendSection(buf, sectionStrArrs, e.flush())
+	e.uint(len(bc.BoolArrs))
+	for _, arr := range bc.BoolArrs {
+		e.bools(arr)
+	}
+	buf = appendSection(buf, sectionBoolArrs, e.flush())
+	e.floats(bc.Floats)
+	return appendSection(buf, sectionFloats, e.flush())
+}
+
+// decodePool decodes the pool section tagged tag into bc, false if tag is
+// not that of a pool.
+func decodePool(tag byte, s *decoder, bc *Bytecode) bool {
+	switch tag {
+	case sectionInts:
+		bc.Ints = s.ints()
+	case sectionStrs:
+		bc.Strs = s.strs()
+	case sectionBools:
+		bc.Bools = s.bools()
+	case sectionIntArrs:
+		bc.IntArrs = make([][]int, s.count())
+		for i := range bc.IntArrs {
+			bc.IntArrs[i] = s.ints()
+		}
+	case sectionStrArrs:
+		bc.StrArrs = make([][]string, s.count())
+		for i := range bc.StrArrs {
+			bc.StrArrs[i] = s.strs()
+		}
+	case sectionBoolArrs:
+		bc.BoolArrs = make([][]bool, s.count())
+		for i := range bc.BoolArrs {
+			bc.BoolArrs[i] = s.bools()
+		}
+	case sectionFloats:
+		bc.Floats = s.floats()
+	default:
+		return false
+	}
+	return true
+}
+
+func appendSection(buf []byte, tag byte, payload []byte) []byte {
+	buf = append(buf, tag)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	return append(buf, payload...)
+}
+
+var errTruncated = errors.New("ezc file is truncated or corrupt")
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) flush() []byte {
+	buf := e.buf
+	e.buf = nil
+	return buf
+}
+
+func (e *encoder) uint(v int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+func (e *encoder) int(v int) {
+	e.buf = binary.AppendVarint(e.buf, int64(v))
+}
+
+func (e *encoder) str(s string) {
+	e.uint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) ints(vals []int) {
+	e.uint(len(vals))
+	for _, v := range vals {
+		e.int(v)
+	}
+}
+
+func (e *encoder) strs(vals []string) {
+	e.uint(len(vals))
+	for _, v := range vals {
+		e.str(v)
+	}
+}
+
+// floats stores each value as its 8 IEEE 754 bytes, little-endian.
+func (e *encoder) floats(vals []float64) {
+	e.uint(len(vals))
+	for _, v := range vals {
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+	}
+}
+
+// bools packs eight values per byte, least significant bit first.
+func (e *encoder) bools(vals []bool) {
+	e.uint(len(vals))
+	var b byte
+	for i, v := range vals {
+		if v {
+			b |= 1 << (i % 8)
+		}
+		if i%8 == 7 || i == len(vals)-1 {
+			e.buf = append(e.buf, b)
+			b = 0
+		}
+	}
+}
+
+func (e *encoder) types(types []Type) {
+	e.uint(len(types))
+	for _, typ := range types {
+		e.int(int(typ))
+	}
+}
+
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 || v > uint64(^uint(0)>>1) {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+func (d *decoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+// count reads a list length, rejecting any that could not possibly fit in
+// the remaining bytes so corrupt input cannot force a huge allocation.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > len(d.buf) {
+		d.err = errTruncated
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) str() string {
+	n := d.count()
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) ints() []int {
+	vals := make([]int, d.count())
+	for i := range vals {
+		vals[i] = d.int()
+	}
+	return vals
+}
+
+func (d *decoder) strs() []string {
+	vals := make([]string, d.count())
+	for i := range vals {
+		vals[i] = d.str()
+	}
+	return vals
+}
+
+func (d *decoder) floats() []float64 {
+	n := d.uint()
+	if d.err != nil || n > len(d.buf)/8 {
+		d.err = errTruncated
+		return nil
+	}
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = math.Float64frombits(binary.LittleEndian.Uint64(d.buf[8*i:]))
+	}
+	d.buf = d.buf[8*n:]
+	return vals
+}
+
+func (d *decoder) bools() []bool {
+	n := d.uint()
+	if d.err != nil || (n+7)/8 > len(d.buf) {
+		d.err = errTruncated
+		return nil
+	}
+	vals := make([]bool, n)
+	for i := range vals {
+		vals[i] = d.buf[i/8]&(1<<(i%8)) != 0
+	}
+	d.buf = d.buf[(n+7)/8:]
+	return vals
+}
+
+func (d *decoder) types() []Type {
+	types := make([]Type, d.count())
+	for i := range types {
+		types[i] = Type(d.int())
+	}
+	return types
+}
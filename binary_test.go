@@ -0,0 +1,204 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// binarySrc uses every pool, a function and a host function, so that its
+// bytecode has every .ezc section.
+const binarySrc = `n
+out total
+nums = [1 2 3]
+words = ['a' 'b\t']
+flags = [true false]
+f = 2.5
+ok = true
+func add a b
+  c = (a * b) + 1
+  return c
+end
+total = add n 4
+greeting = shout 'hi'
+print greeting
+print total
+`
+
+func compileBinarySrc(t *testing.T) *Program {
+	t.Helper()
+	env := NewEnv()
+	err := env.RegisterFunc("shout", []Type{Str}, []Type{Str}, func(args []Value) ([]Value, error) {
+		return []Value{StrValue(strings.ToUpper(args[0].Str))}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := env.Compile(strings.NewReader(binarySrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+// checkSameBytecode compares got and want field by field as printed, which
+// tells an empty slice from a nil one no more than the encoding does.
+func checkSameBytecode(t *testing.T, got, want Bytecode) {
+	t.Helper()
+	gv, wv := reflect.ValueOf(got), reflect.ValueOf(want)
+	for i := 0; i < gv.NumField(); i++ {
+		g, w := fmt.Sprintf("%+v", gv.Field(i).Interface()), fmt.Sprintf("%+v", wv.Field(i).Interface())
+		if g != w {
+			t.Errorf("decoded %s differ:\ngot  %s\nwant %s", gv.Type().Field(i).Name, g, w)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	bc := compileBinarySrc(t).Bytecode
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	checkSameBytecode(t, got, bc)
+	again, err := got.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("encoding decoded bytecode gives different bytes")
+	}
+}
+
+func TestBinaryRoundTripStripped(t *testing.T) {
+	bc := compileBinarySrc(t).Bytecode
+	bc.StripDebug()
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got.Lines != nil || got.Names != nil {
+		t.Errorf("stripped bytecode decoded with lines %v and names %v", got.Lines, got.Names)
+	}
+	if !reflect.DeepEqual(got.OpAddrs, bc.OpAddrs) {
+		t.Error("decoded ops differ")
+	}
+}
+
+func TestUnmarshalBinaryRejects(t *testing.T) {
+	bc := compileBinarySrc(t).Bytecode
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashAt := len(ezcMagic) + 1 // the format version takes one byte
+	withHash := func(hash uint64) []byte {
+		b := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(b[hashAt:], hash)
+		return b
+	}
+	withVersion := append([]byte(ezcMagic), binary.AppendUvarint(nil, ezcFormatVersion+1)...)
+	withVersion = append(withVersion, data[hashAt:]...)
+	withSection := append(append([]byte(nil), data...), sectionHosts+1, 0)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		incompatible bool
+	}{
+		{"empty", nil, false},
+		{"bad magic", append([]byte("EZX\x00"), data[len(ezcMagic):]...), false},
+		{"magic only", data[:len(ezcMagic)], false},
+		{"wrong version", withVersion, true},
+		{"wrong ABI hash", withHash(abiHash + 1), true},
+		{"truncated hash", data[:hashAt+4], false},
+		{"truncated section", data[:len(data)-1], false},
+		{"unknown required section", withSection, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Bytecode{Ints: []int{42}}
+			err := got.UnmarshalBinary(test.data)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if errors.Is(err, ErrIncompatibleBytecode) != test.incompatible {
+				t.Errorf("error %q, want ErrIncompatibleBytecode: %v", err, test.incompatible)
+			}
+			if !reflect.DeepEqual(got.Ints, []int{42}) {
+				t.Error("failed decode changed the bytecode")
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinaryTruncated(t *testing.T) {
+	bc := compileBinarySrc(t).Bytecode
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n++ {
+		var got Bytecode
+		if err := got.UnmarshalBinary(data[:n]); err != nil {
+			continue
+		}
+		// A cut between sections may only drop those after the required
+		// ones.
+		if len(got.OpAddrs) != len(bc.OpAddrs) || len(got.Funcs) != len(bc.Funcs) || len(got.Floats) != len(bc.Floats) {
+			t.Errorf("decoding %d of %d bytes succeeded with required sections cut", n, len(data))
+		}
+	}
+}
+
+func TestUnmarshalBinarySkipsOptionalSection(t *testing.T) {
+	bc := compileBinarySrc(t).Bytecode
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, 200, 2, 0xff, 0xff)
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	checkSameBytecode(t, got, bc)
+}
+
+func TestBinaryRuns(t *testing.T) {
+	prog := compileBinarySrc(t)
+	data, err := prog.Bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Bytecode
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	var want, got bytes.Buffer
+	if err := RunWithOptions(context.Background(), &prog.Bytecode, Options{Output: &want, Env: prog.env}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunWithOptions(context.Background(), &decoded, Options{Output: &got, Env: prog.env}); err != nil {
+		t.Fatal(err)
+	}
+	if want.String() != "HI\n1\n" {
+		t.Errorf("program printed %q", want.String())
+	}
+	if got.String() != want.String() {
+		t.Errorf("decoded program printed %q, want %q", got.String(), want.String())
+	}
+}
@@ -0,0 +1,219 @@
+package ez
+
+import (
+	"strconv"
+
+	"github.com/jakevn/ez/ast"
+)
+
+// block is an if or loop statement whose body spans several lines, closed
+// by 'end'. condJump is the position of the pending jump operand taken when
+// the latest condition is false, or 0 once an 'else' has consumed it.
+// endSlots are the Ints slots of the gotos that leave the block: one per
+// finished branch of an if, one per break of a loop.
+type block struct {
+	kind     string // "if", "while" or "for"
+	line     uint16
+	condJump int
+	endSlots []int
+	hasElse  bool
+
+	// Loops only. topSlot holds the position that re-evaluates the
+	// condition and contSlot the one continue jumps to; for a for loop that
+	// is the increment of its counter, at counter+step.
+	topSlot  int
+	contSlot int
+	counter  int
+	step     int
+}
+
+// beginElse ends the current branch of the innermost if block with a jump
+// to its end and points the pending false condition at the next branch.
+func (p *Parser) beginElse(elseIf bool) error {
+	if len(p.blocks) == 0 || p.blocks[len(p.blocks)-1].kind != "if" {
+		return p.parsingErr(CodeBlock, "'else' without matching 'if'")
+	}
+	block := &p.blocks[len(p.blocks)-1]
+	if block.hasElse {
+		return p.parsingErr(CodeBlock, "'else' after the final 'else' of the if block on line "+strconv.Itoa(int(block.line)))
+	}
+	block.hasElse = !elseIf
+	block.endSlots = append(block.endSlots, p.emitGoto(p.constSlot(0)))
+	p.bc.OpAddrs[block.condJump] = len(p.bc.OpAddrs)
+	block.condJump = 0
+	return nil
+}
+
+// compileIf compiles 'if cond', which opens an if block, or for an 'else if'
+// the next branch of the innermost one, and 'if cond stmt'.
+func (p *Parser) compileIf(s *ast.IfStmt, elseIf bool) error {
+	cond, err := p.compileExpr(s.Cond)
+	if err != nil {
+		return err
+	}
+	p.span = [2]int{s.If.Col - 1, s.Cond.End().Col - 1}
+	if err := p.compileExpression(expressionCtx{args: []string{cond}, op: "if", stmt: true}); err != nil {
+		return err
+	}
+	jump := len(p.bc.OpAddrs)
+	p.bc.OpAddrs = append(p.bc.OpAddrs, 0)
+	switch {
+	case s.Then != nil:
+		p.span = nodeSpan(s.Then)
+		if err := p.compileStmt(s.Then); err != nil {
+			return err
+		}
+		p.bc.OpAddrs[jump] = len(p.bc.OpAddrs)
+	case elseIf:
+		p.blocks[len(p.blocks)-1].condJump = jump
+	default:
+		p.blocks = append(p.blocks, block{kind: "if", line: p.line, condJump: jump})
+	}
+	return nil
+}
+
+// beginWhile compiles 'while cond', which tests cond before every iteration.
+// top is where the ops computing cond, if any, start.
+func (p *Parser) beginWhile(raw string, top int) error {
+	cond, err := p.operandSlot(raw, Bool)
+	if err != nil {
+		return err
+	}
+	p.bc.OpAddrs = append(p.bc.OpAddrs, baselibAddr("if", Bool), cond, 0)
+	p.blocks = append(p.blocks, block{
+		kind:     "while",
+		line:     p.line,
+		condJump: len(p.bc.OpAddrs) - 1,
+		topSlot:  p.constSlot(top),
+		contSlot: -1,
+	})
+	return nil
+}
+
+// beginFor compiles 'for i from a to b', which runs its body for every int
+// from a up to and including b. b is read again before every iteration.
+func (p *Parser) beginFor(id, rawFrom, rawTo string) error {
+	span := p.span
+	from, err := p.operandSlot(rawFrom, Int)
+	if err != nil {
+		return err
+	}
+	p.span = span
+	to, err := p.operandSlot(rawTo, Int)
+	if err != nil {
+		return err
+	}
+	p.span = span
+	typ, counter, found := p.typeAndAddrOfID(id)
+	switch {
+	case !found:
+		counter = p.newAlloc(id, Int)
+	case typ == Und:
+		if err := p.decide(id, Int); err != nil {
+			return err
+		}
+		_, counter, _ = p.typeAndAddrOfID(id)
+	case typ != Int:
+		p.narrowSpan(id)
+		return p.parsingErr(CodeTypeMismatch, "loop counter '"+id+"' must be int, is "+typ.String())
+	}
+	p.bc.OpAddrs = append(p.bc.OpAddrs, iopIntCopy, from, counter)
+	cond := len(p.bc.Bools)
+	p.bc.Bools = append(p.bc.Bools, false)
+	top := len(p.bc.OpAddrs)
+	p.bc.OpAddrs = append(p.bc.OpAddrs, baselibAddr("<=", Int, Int), counter, to, cond)
+	p.bc.OpAddrs = append(p.bc.OpAddrs, baselibAddr("if", Bool), cond, 0)
+	p.blocks = append(p.blocks, block{
+		kind:     "for",
+		line:     p.line,
+		condJump: len(p.bc.OpAddrs) - 1,
+		topSlot:  p.constSlot(top),
+		contSlot: p.constSlot(0),
+		counter:  counter,
+		step:     p.constSlot(1),
+	})
+	return nil
+}
+
+// compileLoopJump compiles break and continue, which act on the innermost
+// loop even when written inside if blocks.
+func (p *Parser) compileLoopJump(ctx expressionCtx) error {
+	if len(ctx.args) > 0 || len(ctx.assgns) > 0 {
+		return p.parsingErr(CodeSyntax, "'"+ctx.op+"' takes no arguments")
+	}
+	for i := len(p.blocks) - 1; i >= 0; i-- {
+		loop := &p.blocks[i]
+		if loop.kind == "if" {
+			continue
+		}
+		switch {
+		case ctx.op == "break":
+			loop.endSlots = append(loop.endSlots, p.emitGoto(p.constSlot(0)))
+		case loop.contSlot >= 0:
+			p.emitGoto(loop.contSlot)
+		default:
+			p.emitGoto(loop.topSlot)
+		}
+		return nil
+	}
+	return p.parsingErr(CodeBlock, "'"+ctx.op+"' outside of a loop")
+}
+
+func (p *Parser) endBlock() {
+	block := p.blocks[len(p.blocks)-1]
+	p.blocks = p.blocks[:len(p.blocks)-1]
+	switch block.kind {
+	case "for":
+		p.bc.Ints[block.contSlot] = len(p.bc.OpAddrs)
+		p.bc.OpAddrs = append(p.bc.OpAddrs, baselibAddr("+", Int, Int), block.counter, block.step, block.counter)
+		p.emitGoto(block.topSlot)
+	case "while":
+		p.emitGoto(block.topSlot)
+	}
+	if block.condJump != 0 {
+		p.bc.OpAddrs[block.condJump] = len(p.bc.OpAddrs)
+	}
+	for _, slot := range block.endSlots {
+		p.bc.Ints[slot] = len(p.bc.OpAddrs)
+	}
+}
+
+// emitGoto appends a goto to the position held in slot and returns slot.
+func (p *Parser) emitGoto(slot int) int {
+	p.bc.OpAddrs = append(p.bc.OpAddrs, baselibAddr("goto", Addr), slot)
+	return slot
+}
+
+// constSlot allocates an Ints slot holding val that no identifier refers to.
+func (p *Parser) constSlot(val int) int {
+	p.bc.Ints = append(p.bc.Ints, val)
+	return len(p.bc.Ints) - 1
+}
+
+// operandSlot returns the slot of raw, an identifier or literal of type typ.
+func (p *Parser) operandSlot(raw string, typ Type) (int, error) {
+	p.narrowSpan(raw)
+	if !ast.IsIdentifier(raw) && !isTemp(raw) {
+		if !ast.IsInt(raw) && !isBool(raw) && !ast.IsFloat(raw) && !isString(raw) {
+			return 0, p.parsingErr(CodeUnknownSymbol, "unknown symbol: "+raw)
+		}
+		if rawTyp := rawToType(raw); rawTyp != typ {
+			return 0, p.parsingErr(CodeTypeMismatch, "expected "+typ.String()+", got '"+raw+"' of type "+rawTyp.String())
+		}
+		addr, _ := p.newAllocInitialize(raw, raw)
+		return addr, nil
+	}
+	idTyp, addr, found := p.typeAndAddrOfID(raw)
+	switch {
+	case !found:
+		return 0, p.undefinedErr(raw)
+	case idTyp == Und:
+		if err := p.decide(raw, typ); err != nil {
+			return 0, err
+		}
+		_, addr, _ = p.typeAndAddrOfID(raw)
+	case idTyp != typ:
+		return 0, p.parsingErr(CodeTypeMismatch, "expected "+typ.String()+", got '"+raw+"' of type "+idTyp.String())
+	}
+	return addr, nil
+}
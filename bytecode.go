@@ -1,9 +1,157 @@
 package ez
 
 type Bytecode struct {
-	OpAddrs []int    `json:"op_addrs,omitempty"`
-	Ints    []int    `json:"ints,omitempty"`
-	Strs    []string `json:"strs,omitempty"`
-	Bools   []bool   `json:"bools,omitempty"`
-	pos     int
+	OpAddrs  []int      `json:"op_addrs,omitempty"`
+	Ints     []int      `json:"ints,omitempty"`
+	Strs     []string   `json:"strs,omitempty"`
+	Bools    []bool     `json:"bools,omitempty"`
+	IntArrs  [][]int    `json:"int_arrs,omitempty"`
+	StrArrs  [][]string `json:"str_arrs,omitempty"`
+	BoolArrs [][]bool   `json:"bool_arrs,omitempty"`
+	Floats   []float64  `json:"floats,omitempty"`
+	Lines    []LineMark `json:"lines,omitempty"`
+	Names    []SlotName `json:"names,omitempty"`
+	Funcs    []FuncInfo `json:"funcs,omitempty"`
+	Hosts    []HostInfo `json:"hosts,omitempty"`
+}
+
+// LineMark records that the ops starting at Pos were compiled from Line.
+type LineMark struct {
+	Pos  int    `json:"pos"`
+	Line uint16 `json:"line"`
+}
+
+// SlotName records the identifier or label that a slot holds. Func is the
+// index in Funcs of the function declaring it, or -1 at the top level.
+type SlotName struct {
+	Type Type   `json:"type"`
+	Slot int    `json:"slot"`
+	Name string `json:"name"`
+	Func int    `json:"func"`
+}
+
+// StripDebug drops the line marks and slot names, which only serve error
+// messages and tools such as the debugger and disassembler.
+func (bc *Bytecode) StripDebug() {
+	bc.Lines = nil
+	bc.Names = nil
+}
+
+// slotName returns the name of slot addr of type typ, empty if it has none.
+func (bc *Bytecode) slotName(typ Type, addr int) string {
+	if typ == Addr {
+		typ = Int
+	}
+	for _, name := range bc.Names {
+		nameTyp := name.Type
+		if nameTyp == Addr {
+			nameTyp = Int
+		}
+		if nameTyp == typ && name.Slot == addr {
+			return name.Name
+		}
+	}
+	return ""
+}
+
+// FuncInfo describes a function defined by the script. Its parameters and
+// locals occupy the Frame slots, which are saved on call and restored on
+// return so that recursive calls each see their own values.
+type FuncInfo struct {
+	Name   string `json:"name"`
+	Entry  int    `json:"entry"`
+	In     []Type `json:"in,omitempty"`
+	Out    []Type `json:"out,omitempty"`
+	Params []int  `json:"params,omitempty"`
+	Frame  Frame  `json:"frame"`
+}
+
+type Frame struct {
+	Ints     SlotRange `json:"ints"`
+	Strs     SlotRange `json:"strs"`
+	Bools    SlotRange `json:"bools"`
+	IntArrs  SlotRange `json:"int_arrs"`
+	StrArrs  SlotRange `json:"str_arrs"`
+	BoolArrs SlotRange `json:"bool_arrs"`
+	Floats   SlotRange `json:"floats"`
+}
+
+// ranges returns the frame's slot ranges in pool order.
+func (f Frame) ranges() []SlotRange {
+	return []SlotRange{f.Ints, f.Strs, f.Bools, f.IntArrs, f.StrArrs, f.BoolArrs, f.Floats}
+}
+
+func (f *Frame) rangePtrs() []*SlotRange {
+	return []*SlotRange{&f.Ints, &f.Strs, &f.Bools, &f.IntArrs, &f.StrArrs, &f.BoolArrs, &f.Floats}
+}
+
+type SlotRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (r SlotRange) contains(slot int) bool {
+	return slot >= r.Start && slot < r.End
+}
+
+// opArgs returns the operand types of the op at pos, resolving the operands
+// of calls, host calls and returns from the function they refer to. ok is
+// false if the opcode or function index is invalid.
+func (bc *Bytecode) opArgs(pos int) (args []Type, ok bool) {
+	op := bc.OpAddrs[pos]
+	if op < 0 || op >= len(opcodes) || opcodes[op].name == "" {
+		return nil, false
+	}
+	args = opcodes[op].args
+	if len(args) == 0 || args[0] != funcRef && args[0] != hostRef {
+		return args, true
+	}
+	if pos+1 >= len(bc.OpAddrs) {
+		return args, true
+	}
+	fn := bc.OpAddrs[pos+1]
+	if op == iopHostCall {
+		if fn < 0 || fn >= len(bc.Hosts) {
+			return nil, false
+		}
+		return append(append([]Type{hostRef}, bc.Hosts[fn].In...), bc.Hosts[fn].Out...), true
+	}
+	if fn < 0 || fn >= len(bc.Funcs) {
+		return nil, false
+	}
+	switch op {
+	case iopCall:
+		args = append(append([]Type{funcRef}, bc.Funcs[fn].In...), bc.Funcs[fn].Out...)
+	case iopReturn:
+		args = append([]Type{funcRef}, bc.Funcs[fn].Out...)
+	}
+	return args, true
+}
+
+// opOuts returns how many of the trailing operands of the valid op at pos
+// are written by it.
+func (bc *Bytecode) opOuts(pos int) int {
+	switch bc.OpAddrs[pos] {
+	case iopCall:
+		return len(bc.Funcs[bc.OpAddrs[pos+1]].Out)
+	case iopHostCall:
+		return len(bc.Hosts[bc.OpAddrs[pos+1]].Out)
+	}
+	return opcodes[bc.OpAddrs[pos]].outs
+}
+
+func (bc *Bytecode) lineAt(pos int) uint16 {
+	lo, hi := 0, len(bc.Lines)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if bc.Lines[mid].Pos <= pos {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0
+	}
+	return bc.Lines[lo-1].Line
 }
@@ -0,0 +1,169 @@
+package ez
+
+// DefaultMaxCallDepth bounds recursion when Options.MaxCallDepth is zero.
+const DefaultMaxCallDepth = 10000
+
+// callFrame holds the caller's values of a function's frame slots while
+// the function runs. Array slots are moved rather than copied, so the callee
+// starts with empty arrays and cannot alias the caller's.
+type callFrame struct {
+	fn       int
+	callPos  int
+	ints     []int
+	strs     []string
+	bools    []bool
+	intArrs  [][]int
+	strArrs  [][]string
+	boolArrs [][]bool
+	floats   []float64
+}
+
+// call enters the function called by the op at p.pos and returns the bytes
+// taken by the saved frame. Arguments are read through the saved frame, as a
+// recursive call passes the caller's own locals into the slots they share.
+func (p *Machine) call() int {
+	fnIndex := p.OpAddrs[p.pos+1]
+	fn := &p.Funcs[fnIndex]
+	fr := callFrame{
+		fn:       fnIndex,
+		callPos:  p.pos,
+		ints:     append([]int(nil), p.Ints[fn.Frame.Ints.Start:fn.Frame.Ints.End]...),
+		strs:     append([]string(nil), p.Strs[fn.Frame.Strs.Start:fn.Frame.Strs.End]...),
+		bools:    append([]bool(nil), p.Bools[fn.Frame.Bools.Start:fn.Frame.Bools.End]...),
+		intArrs:  append([][]int(nil), p.IntArrs[fn.Frame.IntArrs.Start:fn.Frame.IntArrs.End]...),
+		strArrs:  append([][]string(nil), p.StrArrs[fn.Frame.StrArrs.Start:fn.Frame.StrArrs.End]...),
+		boolArrs: append([][]bool(nil), p.BoolArrs[fn.Frame.BoolArrs.Start:fn.Frame.BoolArrs.End]...),
+		floats:   append([]float64(nil), p.Floats[fn.Frame.Floats.Start:fn.Frame.Floats.End]...),
+	}
+	for i := fn.Frame.IntArrs.Start; i < fn.Frame.IntArrs.End; i++ {
+		p.IntArrs[i] = nil
+	}
+	for i := fn.Frame.StrArrs.Start; i < fn.Frame.StrArrs.End; i++ {
+		p.StrArrs[i] = nil
+	}
+	for i := fn.Frame.BoolArrs.Start; i < fn.Frame.BoolArrs.End; i++ {
+		p.BoolArrs[i] = nil
+	}
+	for i, typ := range fn.In {
+		p.transfer(typ, p.OpAddrs[p.pos+2+i], fn.Params[i], &fr, nil)
+	}
+	p.stack = append(p.stack, fr)
+	p.pos = fn.Entry
+	return 8*len(fr.ints) + strsSize(fr.strs) + len(fr.bools) + 8*len(fr.floats)
+}
+
+// ret leaves the innermost function from the return op at p.pos, writing
+// the returned values into the caller's assignment slots. It returns the
+// bytes released by dropping the frame and the callee's arrays.
+func (p *Machine) ret() int {
+	fr := &p.stack[len(p.stack)-1]
+	fn := &p.Funcs[fr.fn]
+	outs := fr.callPos + 2 + len(fn.In)
+	for i, typ := range fn.Out {
+		p.transfer(typ, p.OpAddrs[p.pos+2+i], p.OpAddrs[outs+i], nil, fr)
+	}
+	released := 8*len(fr.ints) + strsSize(fr.strs) + len(fr.bools) + 8*len(fr.floats)
+	for _, arr := range p.IntArrs[fn.Frame.IntArrs.Start:fn.Frame.IntArrs.End] {
+		released += 8 * len(arr)
+	}
+	for _, arr := range p.StrArrs[fn.Frame.StrArrs.Start:fn.Frame.StrArrs.End] {
+		released += strsSize(arr)
+	}
+	for _, arr := range p.BoolArrs[fn.Frame.BoolArrs.Start:fn.Frame.BoolArrs.End] {
+		released += len(arr)
+	}
+	copy(p.Ints[fn.Frame.Ints.Start:], fr.ints)
+	copy(p.Strs[fn.Frame.Strs.Start:], fr.strs)
+	copy(p.Bools[fn.Frame.Bools.Start:], fr.bools)
+	copy(p.IntArrs[fn.Frame.IntArrs.Start:], fr.intArrs)
+	copy(p.StrArrs[fn.Frame.StrArrs.Start:], fr.strArrs)
+	copy(p.BoolArrs[fn.Frame.BoolArrs.Start:], fr.boolArrs)
+	copy(p.Floats[fn.Frame.Floats.Start:], fr.floats)
+	p.pos = outs + len(fn.Out)
+	p.stack = p.stack[:len(p.stack)-1]
+	return released
+}
+
+// transfer copies slot src to slot dst, both of type typ. A non-nil frame
+// redirects slots within its function's frame to the saved values.
+func (p *Machine) transfer(typ Type, src, dst int, srcFrame, dstFrame *callFrame) {
+	switch typ {
+	case Int:
+		*p.intSlot(dstFrame, dst) = *p.intSlot(srcFrame, src)
+	case Str:
+		*p.strSlot(dstFrame, dst) = *p.strSlot(srcFrame, src)
+	case Bool:
+		*p.boolSlot(dstFrame, dst) = *p.boolSlot(srcFrame, src)
+	case Float:
+		*p.floatSlot(dstFrame, dst) = *p.floatSlot(srcFrame, src)
+	case ArrInt:
+		*p.intArrSlot(dstFrame, dst) = append([]int(nil), *p.intArrSlot(srcFrame, src)...)
+	case ArrStr:
+		*p.strArrSlot(dstFrame, dst) = append([]string(nil), *p.strArrSlot(srcFrame, src)...)
+	case ArrBool:
+		*p.boolArrSlot(dstFrame, dst) = append([]bool(nil), *p.boolArrSlot(srcFrame, src)...)
+	}
+}
+
+func (p *Machine) intSlot(fr *callFrame, slot int) *int {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.Ints; r.contains(slot) {
+			return &fr.ints[slot-r.Start]
+		}
+	}
+	return &p.Ints[slot]
+}
+
+func (p *Machine) strSlot(fr *callFrame, slot int) *string {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.Strs; r.contains(slot) {
+			return &fr.strs[slot-r.Start]
+		}
+	}
+	return &p.Strs[slot]
+}
+
+func (p *Machine) boolSlot(fr *callFrame, slot int) *bool {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.Bools; r.contains(slot) {
+			return &fr.bools[slot-r.Start]
+		}
+	}
+	return &p.Bools[slot]
+}
+
+func (p *Machine) floatSlot(fr *callFrame, slot int) *float64 {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.Floats; r.contains(slot) {
+			return &fr.floats[slot-r.Start]
+		}
+	}
+	return &p.Floats[slot]
+}
+
+func (p *Machine) intArrSlot(fr *callFrame, slot int) *[]int {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.IntArrs; r.contains(slot) {
+			return &fr.intArrs[slot-r.Start]
+		}
+	}
+	return &p.IntArrs[slot]
+}
+
+func (p *Machine) strArrSlot(fr *callFrame, slot int) *[]string {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.StrArrs; r.contains(slot) {
+			return &fr.strArrs[slot-r.Start]
+		}
+	}
+	return &p.StrArrs[slot]
+}
+
+func (p *Machine) boolArrSlot(fr *callFrame, slot int) *[]bool {
+	if fr != nil {
+		if r := p.Funcs[fr.fn].Frame.BoolArrs; r.contains(slot) {
+			return &fr.boolArrs[slot-r.Start]
+		}
+	}
+	return &p.BoolArrs[slot]
+}
@@ -0,0 +1,36 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// endlessSrc recurses until n wraps around, long after any call depth limit.
+const endlessSrc = `func f n
+  done = n < 0
+  if done return 0
+  m = n + 1
+  r = f m
+  return r
+end
+x = f 1
+`
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct{ maxDepth, depth int }{{0, DefaultMaxCallDepth}, {50, 50}}
+	for _, test := range tests {
+		bc, err := Parse(strings.NewReader(endlessSrc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMachine(&bc)
+		err = m.Run(context.Background(), Options{MaxCallDepth: test.maxDepth, Output: new(bytes.Buffer)})
+		checkRuntimeErr(t, err, runtimeErrTest{err: ErrStackOverflow, line: 5, detail: strconv.Itoa(test.depth) + " nested calls"})
+		if len(m.stack) != test.depth {
+			t.Errorf("stopped %d calls deep, want %d", len(m.stack), test.depth)
+		}
+	}
+}
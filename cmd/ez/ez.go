@@ -1,18 +1,63 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-
-	"encoding/json"
+	"text/tabwriter"
+	"time"
 
 	"github.com/jakevn/ez"
+	"github.com/jakevn/ez/ast"
 )
 
 func main() {
 	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	if os.Args[1] == "repl" {
+		repl()
+		return
+	}
+	if os.Args[1] == "debug" {
+		files := fileArgs(os.Args[2:])
+		if len(files) != 1 {
+			usage()
+		}
+		if err := debug(files[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if os.Args[1] == "disasm" {
+		files := fileArgs(os.Args[2:])
+		if len(files) != 1 {
+			usage()
+		}
+		if err := disassemble(files[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if os.Args[1] == "fmt" {
+		if err := format(fileArgs(os.Args[2:])); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if os.Args[1] == "run" {
+		// 'ez run FILE' is the same as 'ez FILE'
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		if len(os.Args) < 2 {
+			usage()
+		}
+	}
 	filePath := os.Args[1]
 	file, err := os.OpenFile(filePath, os.O_RDONLY, 0600)
 	if err != nil {
@@ -21,22 +66,141 @@ func main() {
 	defer file.Close()
 
 	var bc ez.Bytecode
-	if strings.HasSuffix(filePath, ".ezc") {
+	compiled := strings.HasSuffix(filePath, ".ezc")
+	if compiled {
 		bc, err = decode(file)
 	} else {
 		bc, err = ez.Parse(file)
-		if err == nil && hasFlag("c") {
-			if err := saveByteCode(bc); err != nil {
-				log.Fatal(err)
-			}
-			return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	save := !compiled && hasFlag("c")
+	if save && hasFlag("strip") {
+		// stripped first so that the optimizer need not keep named slots
+		bc.StripDebug()
+	}
+	if hasFlag("O") {
+		if err := ez.Optimize(&bc); err != nil {
+			log.Fatal(err)
 		}
 	}
+	if save {
+		if err := saveByteCode(bc); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var opts ez.Options
+	if hasFlag("profile") {
+		opts.Profile = &ez.Profile{}
+	}
+	err = run(ez.NewMachine(&bc), opts)
+	if opts.Profile != nil {
+		printProfile(os.Stderr, opts.Profile, filePath)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+// run runs m to its end, resuming it straight away whenever it yields.
+func run(m *ez.Machine, opts ez.Options) error {
+	err := m.Run(context.Background(), opts)
+	for err == nil && m.Status() == ez.Suspended {
+		err = m.Resume(context.Background(), opts)
+	}
+	return err
+}
 
-	ez.Run(&bc)
+// printProfile writes the opcodes and source lines that took the most time,
+// quoting the lines from filePath if it is source.
+func printProfile(w io.Writer, prof *ez.Profile, filePath string) {
+	const top = 10
+	var src []string
+	if !strings.HasSuffix(filePath, ".ezc") {
+		if data, err := os.ReadFile(filePath); err == nil {
+			src = strings.Split(string(data), "\n")
+		}
+	}
+	count, total := prof.Total()
+	percent := func(d time.Duration) string {
+		if total == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(100*float64(d)/float64(total), 'f', 1, 64) + "%"
+	}
+	fmt.Fprintf(w, "%d ops in %v\n\n", count, total)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "opcode\tcount\ttime\t%%\n")
+	for i, stat := range prof.Ops() {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%s\n", stat.Op, stat.Count, stat.Time, percent(stat.Time))
+	}
+	fmt.Fprintf(tw, "\nline\tcount\ttime\t%%\n")
+	for i, stat := range prof.Lines() {
+		if i == top {
+			break
+		}
+		line := strconv.Itoa(int(stat.Line))
+		if stat.Line > 0 && int(stat.Line) <= len(src) {
+			line += ": " + strings.TrimSpace(src[stat.Line-1])
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%s\n", line, stat.Count, stat.Time, percent(stat.Time))
+	}
+	tw.Flush()
+}
+
+// format prints each script in canonical form, or with -w writes it back
+// to its file.
+func format(files []string) error {
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		f, err := ast.ParseFile(strings.NewReader(string(data)), ez.NewEnv().IsFunc)
+		if err != nil {
+			return fmt.Errorf("%s:%w", file, err)
+		}
+		var buf strings.Builder
+		if err := ast.Fprint(&buf, f); err != nil {
+			return err
+		}
+		if !hasFlag("w") {
+			fmt.Print(buf.String())
+			continue
+		}
+		if buf.String() != string(data) {
+			if err := os.WriteFile(file, []byte(buf.String()), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func usage() {
+	log.Fatal(`usage:
+  ez [run] FILE [-O] [-c [-strip]] [-profile]
+  ez debug FILE
+  ez disasm FILE [-O]
+  ez fmt [-w] FILE...
+  ez repl`)
+}
+
+// fileArgs returns args without the flags.
+func fileArgs(args []string) []string {
+	var files []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+		}
+	}
+	return files
 }
 
 func saveByteCode(bc ez.Bytecode) error {
@@ -48,12 +212,154 @@ func saveByteCode(bc ez.Bytecode) error {
 		return err
 	}
 	defer file.Close()
-	return json.NewEncoder(file).Encode(bc)
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// repl reads lines from stdin and runs each one as soon as it is complete.
+func repl() {
+	session := ez.NewSession(ez.Options{})
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if session.Pending() {
+			fmt.Print("... ")
+		} else {
+			fmt.Print("> ")
+		}
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case ":quit", ":q":
+			return
+		case ":reset":
+			session.Reset()
+		case ":vars":
+			for _, v := range session.Vars() {
+				fmt.Printf("%s %s = %v\n", v.Name, v.Type, v.Value)
+			}
+		default:
+			if err := session.Eval(context.Background(), line); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// debug runs an .ez file under the debugger, reading commands from stdin:
+// b/d LINE to set or delete a breakpoint, s to step, c to continue, l to list
+// locals and q to quit.
+func debug(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	src := strings.Split(string(data), "\n")
+	prog, err := ez.Compile(strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+	dbg, err := prog.Debug(nil, ez.Options{})
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if dbg.Done() {
+			fmt.Println("program finished")
+		} else if line := int(dbg.Line()); line > 0 && line <= len(src) {
+			at := ""
+			if fn := dbg.Func(); fn != "" {
+				at = " in " + fn
+			}
+			fmt.Printf("%d%s: %s\n", line, at, strings.TrimSpace(src[line-1]))
+		}
+		fmt.Print("(debug) ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return nil
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "b", "d":
+			if len(fields) != 2 {
+				fmt.Println("usage: " + fields[0] + " LINE")
+				continue
+			}
+			line, err := strconv.ParseUint(fields[1], 10, 16)
+			if err != nil {
+				fmt.Println("invalid line: " + fields[1])
+				continue
+			}
+			if fields[0] == "b" {
+				dbg.SetBreakpoint(uint16(line))
+			} else {
+				dbg.ClearBreakpoint(uint16(line))
+			}
+		case "s":
+			err = dbg.Step(context.Background())
+		case "c":
+			err = dbg.Continue(context.Background())
+		case "l":
+			for _, v := range dbg.Locals() {
+				fmt.Printf("%s %s = %v\n", v.Name, v.Type, v.Value)
+			}
+		case "q":
+			return nil
+		default:
+			fmt.Println("commands: b LINE, d LINE, s, c, l, q")
+		}
+		if err != nil {
+			fmt.Println(err)
+			err = nil
+		}
+	}
+}
+
+// disassemble prints the bytecode of an .ez or .ezc file, quoting the source
+// lines when they are available. With -O it prints the optimized bytecode.
+func disassemble(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var bc ez.Bytecode
+	compiled := strings.HasSuffix(filePath, ".ezc")
+	if compiled {
+		err = bc.UnmarshalBinary(data)
+	} else {
+		bc, err = ez.Parse(strings.NewReader(string(data)))
+	}
+	if err != nil {
+		return err
+	}
+	if hasFlag("O") {
+		if err := ez.Optimize(&bc); err != nil {
+			return err
+		}
+	}
+	if compiled {
+		return bc.Disassemble(os.Stdout)
+	}
+	return bc.DisassembleSource(os.Stdout, string(data))
 }
 
 func decode(reader io.Reader) (ez.Bytecode, error) {
 	var ezb ez.Bytecode
-	return ezb, json.NewDecoder(reader).Decode(&ezb)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return ezb, err
+	}
+	return ezb, ezb.UnmarshalBinary(data)
 }
 
 func hasFlag(flag string) bool {
@@ -0,0 +1,125 @@
+package ez
+
+import (
+	"context"
+	"errors"
+)
+
+// Debugger runs a program a source line at a time. It pauses before the
+// first op of every line with a breakpoint and reports the variables in
+// scope by the names the script gave them.
+type Debugger struct {
+	m           *Machine
+	opts        Options
+	lineStarts  map[int]uint16
+	breakpoints map[uint16]bool
+	started     bool
+	err         error
+}
+
+// Debug prepares a run of prog with the given inputs. Nothing runs until
+// Step or Continue is called.
+func (prog *Program) Debug(inputs map[string]any, opts Options) (*Debugger, error) {
+	m := prog.NewMachine()
+	if err := m.SetInputs(inputs); err != nil {
+		return nil, err
+	}
+	d := &Debugger{
+		m:           m,
+		opts:        opts,
+		lineStarts:  make(map[int]uint16, len(m.Lines)),
+		breakpoints: map[uint16]bool{},
+	}
+	for _, mark := range m.Lines {
+		d.lineStarts[mark.Pos] = mark.Line
+	}
+	return d, nil
+}
+
+func (d *Debugger) SetBreakpoint(line uint16) {
+	d.breakpoints[line] = true
+}
+
+func (d *Debugger) ClearBreakpoint(line uint16) {
+	delete(d.breakpoints, line)
+}
+
+// Step runs until the start of the next source line reached, which may be
+// inside a function the current line calls.
+func (d *Debugger) Step(ctx context.Context) error {
+	return d.run(ctx, func(pos int) bool {
+		_, ok := d.lineStarts[pos]
+		return ok
+	})
+}
+
+// Continue runs until a breakpoint or the end of the program.
+func (d *Debugger) Continue(ctx context.Context) error {
+	atBreakpoint := func(pos int) bool {
+		line, ok := d.lineStarts[pos]
+		return ok && d.breakpoints[line]
+	}
+	// A run never pauses before the op it resumes at, which would
+	// skip a breakpoint on the first line.
+	if !d.started && atBreakpoint(d.m.pos) {
+		d.started = true
+		return nil
+	}
+	return d.run(ctx, atBreakpoint)
+}
+
+func (d *Debugger) run(ctx context.Context, stop func(pos int) bool) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.started = true
+	opts := d.opts
+	opts.stop = stop
+	err := d.m.Run(ctx, opts)
+	if errors.Is(err, errPaused) {
+		return nil
+	}
+	if err != nil {
+		d.err = err
+	}
+	return err
+}
+
+// Done reports whether the program has finished or failed.
+func (d *Debugger) Done() bool {
+	return d.err != nil || d.m.Done()
+}
+
+// Line returns the source line of the next op to run, 0 once done.
+func (d *Debugger) Line() uint16 {
+	if d.Done() {
+		return 0
+	}
+	return d.m.lineAt(d.m.pos)
+}
+
+// Func returns the name of the function being run, empty at the top level.
+func (d *Debugger) Func() string {
+	if len(d.m.stack) == 0 {
+		return ""
+	}
+	return d.m.Funcs[d.m.stack[len(d.m.stack)-1].fn].Name
+}
+
+// Locals returns the variables of the innermost function being run, or the
+// top level ones outside functions. Variables not yet assigned hold their
+// zero value, or whatever the previous call left in them.
+func (d *Debugger) Locals() []Var {
+	fn := -1
+	if len(d.m.stack) > 0 {
+		fn = d.m.stack[len(d.m.stack)-1].fn
+	}
+	var vars []Var
+	for _, name := range d.m.Names {
+		if name.Func != fn || !isValueType(name.Type) {
+			continue
+		}
+		vars = append(vars, Var{Name: name.Name, Type: name.Type, Value: d.m.valueAt(name.Type, name.Slot).Any()})
+	}
+	return vars
+}
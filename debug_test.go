@@ -0,0 +1,89 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const debugSrc = `func sq n
+  r = n * n
+  return r
+end
+a = 2
+a = a + 1
+b = sq a
+print b
+`
+
+func TestDebugger(t *testing.T) {
+	prog, err := Compile(strings.NewReader(debugSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d, err := prog.Debug(nil, Options{Output: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	steps := []struct {
+		name   string
+		do     func() error
+		line   uint16
+		fn     string
+		locals []Var
+	}{
+		{
+			name: "continue to a breakpoint on the first line",
+			do: func() error {
+				d.SetBreakpoint(1)
+				d.SetBreakpoint(3)
+				return d.Continue(ctx)
+			},
+			line: 1, locals: []Var{{"a", Int, 2}, {"b", Int, 0}},
+		},
+		{
+			name: "step",
+			do:   func() error { return d.Step(ctx) },
+			line: 6, locals: []Var{{"a", Int, 2}, {"b", Int, 0}},
+		},
+		{
+			name: "continue into a function",
+			do:   func() error { return d.Continue(ctx) },
+			line: 3, fn: "sq", locals: []Var{{"n", Int, 3}, {"r", Int, 9}},
+		},
+		{
+			name: "step out of a function",
+			do: func() error {
+				d.ClearBreakpoint(3)
+				return d.Step(ctx)
+			},
+			line: 8, locals: []Var{{"a", Int, 3}, {"b", Int, 9}},
+		},
+		{
+			name:   "continue to the end",
+			do:     func() error { return d.Continue(ctx) },
+			locals: []Var{{"a", Int, 3}, {"b", Int, 9}},
+		},
+	}
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if d.Line() != step.line || d.Func() != step.fn {
+			t.Errorf("%s: stopped on line %d in %q, want line %d in %q", step.name, d.Line(), d.Func(), step.line, step.fn)
+		}
+		if locals := d.Locals(); !slices.Equal(locals, step.locals) {
+			t.Errorf("%s: locals %v, want %v", step.name, locals, step.locals)
+		}
+	}
+	if !d.Done() {
+		t.Error("not done after continuing past the last breakpoint")
+	}
+	if got := out.String(); got != "9\n" {
+		t.Errorf("printed %q, want %q", got, "9\n")
+	}
+}
@@ -0,0 +1,111 @@
+package ez
+
+import (
+	"io"
+	"strconv"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "WARNING"
+	}
+	return "ERROR"
+}
+
+// Diagnostic codes are stable across releases so that tools can match on
+// them rather than on the message text.
+const (
+	CodeLimit         = "E001" // file or line exceeds MaxLines or MaxLineLen
+	CodeSyntax        = "E002" // malformed statement
+	CodeUnknownSymbol = "E003" // token that is not an identifier, literal, label or function
+	CodeUndefined     = "E004" // reference to an identifier that has no value yet
+	CodeTypeMismatch  = "E005" // value of one type used where another is required
+	CodeNoSignature   = "E006" // no overload accepts the given arguments and assignments
+	CodeInference     = "E007" // type of an identifier cannot be inferred
+	CodeDuplicate     = "E008" // identifier, parameter or function declared twice
+	CodeBlock         = "E009" // func, else, end, return or parameter declaration out of place
+	CodeParam         = "E010" // out parameter never assigned
+	CodeInternal      = "E999" // parser invariant violated
+
+	CodeUnreachable = "W001" // statement after a jump that nothing jumps back to
+)
+
+// Diagnostic is a problem found while parsing. Columns are 1-based byte
+// offsets into the line; EndCol is exclusive. A zero Col means the problem
+// concerns the whole line or file rather than a span of it.
+type Diagnostic struct {
+	Line     uint16
+	Col      int
+	EndCol   int
+	Severity Severity
+	Code     string
+	Message  string
+	Hint     string
+}
+
+func (d *Diagnostic) Error() string {
+	msg := d.Severity.String() + " - "
+	if d.Line > 0 {
+		msg += "line " + strconv.Itoa(int(d.Line)) + ": "
+	}
+	return msg + d.Message
+}
+
+func (d *Diagnostic) withHint(hint string) *Diagnostic {
+	d.Hint = hint
+	return d
+}
+
+// ParseDiagnostics parses the whole of reader, reporting every erroneous
+// line instead of stopping at the first. The returned Bytecode is only
+// meaningful when no diagnostic has SeverityError.
+func ParseDiagnostics(reader io.Reader) (Bytecode, []Diagnostic) {
+	return parseDiagnostics(newParser(nil), reader)
+}
+
+// ParseDiagnostics is like the package level ParseDiagnostics but also
+// resolves the functions registered with e.
+func (e *Env) ParseDiagnostics(reader io.Reader) (Bytecode, []Diagnostic) {
+	return parseDiagnostics(newParser(e), reader)
+}
+
+func parseDiagnostics(p *Parser, reader io.Reader) (Bytecode, []Diagnostic) {
+	p.collectDiags = true
+	bc, err := p.parseInternal(reader)
+	if err != nil {
+		p.report(err)
+	}
+	return bc, p.diags
+}
+
+// warn records a warning at p.span when collecting diagnostics. Parse and
+// Compile, which only report errors, drop it.
+func (p *Parser) warn(code, msg string) {
+	if !p.collectDiags {
+		return
+	}
+	diag := p.parsingErr(code, msg)
+	diag.Severity = SeverityWarning
+	p.diags = append(p.diags, *diag)
+}
+
+// report records err as a diagnostic when collecting them and returns nil,
+// otherwise it returns err unchanged.
+func (p *Parser) report(err error) error {
+	if !p.collectDiags {
+		return err
+	}
+	if diag, ok := err.(*Diagnostic); ok {
+		p.diags = append(p.diags, *diag)
+	} else {
+		p.diags = append(p.diags, Diagnostic{Line: p.line, Severity: SeverityError, Code: CodeInternal, Message: err.Error()})
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package ez
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes a readable listing of bc to w, one op per line, with
+// jump targets labelled and source line numbers annotated.
+func (bc *Bytecode) Disassemble(w io.Writer) error {
+	return bc.disassemble(w, nil)
+}
+
+// DisassembleSource is like Disassemble but quotes each source line of src
+// above the ops compiled from it.
+func (bc *Bytecode) DisassembleSource(w io.Writer, src string) error {
+	return bc.disassemble(w, strings.Split(src, "\n"))
+}
+
+func (bc *Bytecode) disassemble(w io.Writer, srcLines []string) error {
+	bw := bufio.NewWriter(w)
+	labels := bc.jumpLabels()
+	written := bc.writtenSlots()
+	funcEntries := map[int]string{}
+	for _, fn := range bc.Funcs {
+		funcEntries[fn.Entry] = fn.Name
+	}
+	nextMark := 0
+	for pos := 0; pos <= len(bc.OpAddrs); {
+		for nextMark < len(bc.Lines) && bc.Lines[nextMark].Pos <= pos {
+			line := bc.Lines[nextMark].Line
+			bw.WriteString("; line " + strconv.Itoa(int(line)))
+			if int(line) <= len(srcLines) {
+				bw.WriteString(": " + strings.TrimSpace(srcLines[line-1]))
+			}
+			bw.WriteString("\n")
+			nextMark++
+		}
+		if name, ok := funcEntries[pos]; ok {
+			bw.WriteString("func " + name + ":\n")
+		}
+		if label, ok := labels[pos]; ok {
+			bw.WriteString(label + ":\n")
+		}
+		if pos == len(bc.OpAddrs) {
+			break
+		}
+		args, ok := bc.opArgs(pos)
+		if !ok || pos+len(args) >= len(bc.OpAddrs) {
+			bw.WriteString("  " + padPos(pos) + "  ??? " + strconv.Itoa(bc.OpAddrs[pos]) + "\n")
+			pos++
+			continue
+		}
+		bw.WriteString("  " + padPos(pos) + "  " + bc.disassembleOp(pos, args, labels, written) + "\n")
+		pos += 1 + len(args)
+	}
+	return bw.Flush()
+}
+
+func (bc *Bytecode) disassembleOp(pos int, args []Type, labels map[int]string, written map[slotKey]bool) string {
+	op := bc.OpAddrs[pos]
+	text := opcodes[op].name
+	for len(text) < 10 && len(args) > 0 {
+		text += " "
+	}
+	outs := bc.opOuts(pos)
+	for i, typ := range args {
+		addr := bc.OpAddrs[pos+1+i]
+		if i == len(args)-outs {
+			text += " ->"
+		}
+		text += " "
+		switch typ {
+		case funcRef:
+			text += bc.Funcs[addr].Name
+		case hostRef:
+			text += bc.Hosts[addr].Name
+		case jumpPos:
+			text += "else " + labelOrPos(labels, addr)
+		case Addr:
+			text += "int@" + strconv.Itoa(addr)
+			if name := bc.slotName(typ, addr); name != "" {
+				text += "(" + name + ")"
+			}
+			if addr < len(bc.Ints) {
+				text += " (" + labelOrPos(labels, bc.Ints[addr]) + ")"
+			}
+		default:
+			text += typ.String() + "@" + strconv.Itoa(addr)
+			if name := bc.slotName(typ, addr); name != "" {
+				text += "(" + name + ")"
+			}
+			if !written[slotKey{typ, addr}] {
+				text += bc.slotValue(typ, addr)
+			}
+		}
+	}
+	return text
+}
+
+// slotValue renders the initial value of a scalar slot that no op writes,
+// which makes it a constant unless the host sets it as an in parameter.
+func (bc *Bytecode) slotValue(typ Type, addr int) string {
+	switch {
+	case typ == Int && addr < len(bc.Ints):
+		return "=" + strconv.Itoa(bc.Ints[addr])
+	case typ == Str && addr < len(bc.Strs):
+		return "=" + strconv.Quote(bc.Strs[addr])
+	case typ == Bool && addr < len(bc.Bools):
+		return "=" + strconv.FormatBool(bc.Bools[addr])
+	case typ == Float && addr < len(bc.Floats):
+		return "=" + formatFloat(bc.Floats[addr])
+	}
+	return ""
+}
+
+type slotKey struct {
+	typ  Type
+	addr int
+}
+
+func (bc *Bytecode) writtenSlots() map[slotKey]bool {
+	written := map[slotKey]bool{}
+	for _, fn := range bc.Funcs {
+		for i, typ := range fn.In {
+			written[slotKey{typ, fn.Params[i]}] = true
+		}
+	}
+	for pos := 0; pos < len(bc.OpAddrs); {
+		args, ok := bc.opArgs(pos)
+		if !ok || pos+len(args) >= len(bc.OpAddrs) {
+			pos++
+			continue
+		}
+		outs := bc.opOuts(pos)
+		for i := len(args) - outs; i < len(args); i++ {
+			written[slotKey{args[i], bc.OpAddrs[pos+1+i]}] = true
+		}
+		pos += 1 + len(args)
+	}
+	return written
+}
+
+// jumpLabels names every position that an if or goto can jump to.
+func (bc *Bytecode) jumpLabels() map[int]string {
+	targets := map[int]bool{}
+	for pos := 0; pos < len(bc.OpAddrs); {
+		args, ok := bc.opArgs(pos)
+		if !ok || pos+len(args) >= len(bc.OpAddrs) {
+			pos++
+			continue
+		}
+		for i, typ := range args {
+			addr := bc.OpAddrs[pos+1+i]
+			switch {
+			case typ == jumpPos:
+				targets[addr] = true
+			case typ == Addr && addr >= 0 && addr < len(bc.Ints):
+				targets[bc.Ints[addr]] = true
+			}
+		}
+		pos += 1 + len(args)
+	}
+	sorted := make([]int, 0, len(targets))
+	for target := range targets {
+		sorted = append(sorted, target)
+	}
+	sort.Ints(sorted)
+	labels := make(map[int]string, len(sorted))
+	for i, target := range sorted {
+		labels[target] = "L" + strconv.Itoa(i)
+	}
+	return labels
+}
+
+func labelOrPos(labels map[int]string, pos int) string {
+	if label, ok := labels[pos]; ok {
+		return label
+	}
+	return strconv.Itoa(pos)
+}
+
+func padPos(pos int) string {
+	s := strconv.Itoa(pos)
+	for len(s) < 4 {
+		s = "0" + s
+	}
+	return s
+}
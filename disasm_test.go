@@ -0,0 +1,48 @@
+package ez
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassembleSource(t *testing.T) {
+	const src = `func sq n
+  r = n * n
+  return r
+end
+x = sq 3
+if x > 5
+  print x
+end
+`
+	const want = `; line 1: func sq n
+  0000  goto       int@0 (L0)
+; line 2: r = n * n
+func sq:
+  0002  *          int@1(n) int@1(n) -> int@2(r)
+; line 3: return r
+  0006  return     sq int@2(r)
+; line 4: end
+  0009  missingreturn sq
+; line 5: x = sq 3
+L0:
+  0011  call       sq int@3=3 -> int@4(x)
+; line 6: if x > 5
+  0015  >          int@4(x) int@5=5 -> bool@0
+  0019  if         bool@0 else L1
+; line 7: print x
+  0022  print      int@4(x)
+L1:
+`
+	bc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := bc.DisassembleSource(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("disassembled\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package ez
+
+import (
+	"strconv"
+
+	"github.com/jakevn/ez/ast"
+)
+
+// compileAssign compiles the assignment of rhs to lhs, or with no lhs the
+// evaluation of rhs for its effects. A call or infix expression making up
+// rhs compiles into lhs directly; what is nested within it compiles into
+// temporaries first, so that what remains is a single op.
+func (p *Parser) compileAssign(lhs []string, rhs ast.Expr) error {
+	span := p.span
+	switch x := rhs.(type) {
+	case *ast.ArrayLit:
+		args, err := p.compileArgs(x.Elts)
+		if err != nil {
+			return err
+		}
+		p.span = span
+		return p.compileExpression(expressionCtx{assgns: lhs, args: args, array: true})
+	case *ast.CallExpr, *ast.BinaryExpr:
+		op, args, err := p.compileCallArgs(x)
+		if err != nil {
+			return err
+		}
+		p.span = span
+		call, isCall := x.(*ast.CallExpr)
+		stmt := isCall && call.FunIndex == 0 && len(lhs) == 0
+		return p.compileExpression(expressionCtx{assgns: lhs, args: args, op: op, stmt: stmt})
+	}
+	arg, err := p.compileExpr(rhs)
+	if err != nil || len(lhs) == 0 {
+		return err
+	}
+	p.span = span
+	return p.compileExpression(expressionCtx{assgns: lhs, args: []string{arg}})
+}
+
+// compileExpr compiles x into a new temporary unless it is an operand
+// already, and returns the operand holding its value.
+func (p *Parser) compileExpr(x ast.Expr) (string, error) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name, nil
+	case *ast.BasicLit:
+		return x.Value, nil
+	case *ast.Label:
+		return "~" + x.Name, nil
+	case *ast.BadExpr:
+		p.span = nodeSpan(x)
+		return "", p.parsingErr(CodeUnknownSymbol, "unknown symbol: "+x.Text)
+	case *ast.ParenExpr:
+		switch x.X.(type) {
+		case *ast.CallExpr, *ast.BinaryExpr:
+			return p.compileTemp(x.X, nodeSpan(x))
+		}
+		return p.compileExpr(x.X)
+	case *ast.CallExpr, *ast.BinaryExpr:
+		return p.compileTemp(x, nodeSpan(x))
+	}
+	p.span = nodeSpan(x)
+	return "", p.parsingErr(CodeSyntax, "array literal must be assigned to a single identifier")
+}
+
+func (p *Parser) compileArgs(exprs []ast.Expr) ([]string, error) {
+	args := make([]string, len(exprs))
+	for i, x := range exprs {
+		var err error
+		if args[i], err = p.compileExpr(x); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// compileCallArgs compiles the arguments of a call or infix expression and
+// returns the function it applies to them.
+func (p *Parser) compileCallArgs(x ast.Expr) (string, []string, error) {
+	var op string
+	var exprs []ast.Expr
+	switch x := x.(type) {
+	case *ast.CallExpr:
+		op, exprs = x.Fun.Name, x.Args
+	case *ast.BinaryExpr:
+		op, exprs = x.Op, []ast.Expr{x.X, x.Y}
+	}
+	args, err := p.compileArgs(exprs)
+	return op, args, err
+}
+
+// compileTemp compiles 'temp = x' for a fresh temporary, where x is a call or
+// infix expression spanning span.
+func (p *Parser) compileTemp(x ast.Expr, span [2]int) (string, error) {
+	op, args, err := p.compileCallArgs(x)
+	if err != nil {
+		return "", err
+	}
+	p.temps++
+	temp := "%" + strconv.Itoa(p.temps)
+	p.span = span
+	err = p.compileExpression(expressionCtx{assgns: []string{temp}, args: args, op: op})
+	return temp, err
+}
+
+// nodeSpan returns the byte offsets within its line of the first byte of n
+// and one past its last.
+func nodeSpan(n ast.Node) [2]int {
+	return [2]int{n.Pos().Col - 1, n.End().Col - 1}
+}
+
+// isTemp reports whether str names a compiler-allocated temporary, which
+// no script identifier can.
+func isTemp(str string) bool {
+	return len(str) > 1 && str[0] == '%' && ast.IsInt(str[1:])
+}
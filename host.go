@@ -0,0 +1,275 @@
+package ez
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/jakevn/ez/ast"
+)
+
+var (
+	ErrUnboundHostFunc = errors.New("host function not registered")
+	ErrHostResult      = errors.New("host function returned wrong values")
+)
+
+// HostFunc is a Go function callable from scripts. args holds one Value per
+// declared in type and the returned slice must match the declared out types.
+type HostFunc func(args []Value) ([]Value, error)
+
+// Value carries a single argument to or result of a HostFunc. Only the field
+// matching Type is meaningful.
+type Value struct {
+	Type    Type
+	Int     int
+	Str     string
+	Bool    bool
+	Float   float64
+	IntArr  []int
+	StrArr  []string
+	BoolArr []bool
+}
+
+func IntValue(i int) Value         { return Value{Type: Int, Int: i} }
+func StrValue(s string) Value      { return Value{Type: Str, Str: s} }
+func BoolValue(b bool) Value       { return Value{Type: Bool, Bool: b} }
+func FloatValue(f float64) Value   { return Value{Type: Float, Float: f} }
+func IntArrValue(a []int) Value    { return Value{Type: ArrInt, IntArr: a} }
+func StrArrValue(a []string) Value { return Value{Type: ArrStr, StrArr: a} }
+func BoolArrValue(a []bool) Value  { return Value{Type: ArrBool, BoolArr: a} }
+
+// Any returns the Go value held by v.
+func (v Value) Any() any {
+	switch v.Type {
+	case Int:
+		return v.Int
+	case Str:
+		return v.Str
+	case Bool:
+		return v.Bool
+	case Float:
+		return v.Float
+	case ArrInt:
+		return v.IntArr
+	case ArrStr:
+		return v.StrArr
+	case ArrBool:
+		return v.BoolArr
+	}
+	return nil
+}
+
+// Env holds the host functions available to scripts parsed and run with it.
+// Register every function before parsing; an Env must not be modified while
+// scripts using it run.
+type Env struct {
+	funcs map[string][]Func
+	impls map[string][]HostFunc
+}
+
+func NewEnv() *Env {
+	return &Env{funcs: map[string][]Func{}, impls: map[string][]HostFunc{}}
+}
+
+// RegisterFunc makes fn callable from scripts as name. A name may be
+// registered several times with different in types, like the overloads of
+// the built-in functions, but may not shadow a built-in or keyword.
+func (e *Env) RegisterFunc(name string, in, out []Type, fn func(args []Value) ([]Value, error)) error {
+	if !ast.IsIdentifier(name) || isFuncCall(name) {
+		return errors.New("host function name '" + name + "' is not a valid identifier or is reserved")
+	}
+	for _, typ := range append(append([]Type{}, in...), out...) {
+		if !isValueType(typ) {
+			return errors.New("host function '" + name + "' uses unsupported type " + typ.String())
+		}
+	}
+	for _, fun := range e.funcs[name] {
+		if typesEqual(fun.In, in) {
+			return errors.New("host function '" + name + "' is already registered for these in types")
+		}
+	}
+	e.funcs[name] = append(e.funcs[name], Func{
+		In:      append([]Type(nil), in...),
+		Out:     append([]Type(nil), out...),
+		addr:    iopHostCall,
+		fnIndex: len(e.funcs[name]),
+	})
+	e.impls[name] = append(e.impls[name], fn)
+	return nil
+}
+
+// IsFunc reports whether scripts parsed with e can call name without
+// declaring it: a built-in function, or one registered with e. A nil Env
+// has only the built-ins. It suits ast.Parser.Funcs.
+func (e *Env) IsFunc(name string) bool {
+	if isFuncCall(name) {
+		return true
+	}
+	if e == nil {
+		return false
+	}
+	_, ok := e.funcs[name]
+	return ok
+}
+
+func (e *Env) Parse(reader io.Reader) (Bytecode, error) {
+	return newParser(e).parseInternal(reader)
+}
+
+func (e *Env) Compile(reader io.Reader) (*Program, error) {
+	return compile(newParser(e), reader)
+}
+
+// lookup finds the implementation registered for the signature of info.
+func (e *Env) lookup(info HostInfo) (HostFunc, bool) {
+	if e == nil {
+		return nil, false
+	}
+	for i, fun := range e.funcs[info.Name] {
+		if typesEqual(fun.In, info.In) && typesEqual(fun.Out, info.Out) {
+			return e.impls[info.Name][i], true
+		}
+	}
+	return nil, false
+}
+
+// HostInfo names a host function called by the bytecode. The function itself
+// is bound by name and signature when the bytecode is run.
+type HostInfo struct {
+	Name string `json:"name"`
+	In   []Type `json:"in,omitempty"`
+	Out  []Type `json:"out,omitempty"`
+}
+
+func isValueType(typ Type) bool {
+	switch typ {
+	case Int, Str, Bool, Float, ArrInt, ArrStr, ArrBool:
+		return true
+	}
+	return false
+}
+
+func typesEqual(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hostIndex returns the index in Bytecode.Hosts of the host function fun
+// named name, adding it on first use.
+func (p *Parser) hostIndex(name string, fun Func) int {
+	for i, info := range p.bc.Hosts {
+		if info.Name == name && typesEqual(info.In, fun.In) {
+			return i
+		}
+	}
+	p.bc.Hosts = append(p.bc.Hosts, HostInfo{Name: name, In: fun.In, Out: fun.Out})
+	return len(p.bc.Hosts) - 1
+}
+
+// bindHosts resolves every host function of p against env.
+func (p *Bytecode) bindHosts(env *Env) ([]HostFunc, error) {
+	fns := make([]HostFunc, len(p.Hosts))
+	for i, info := range p.Hosts {
+		fn, ok := env.lookup(info)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s %s", ErrUnboundHostFunc, info.Name, funcSignature(info.In, info.Out))
+		}
+		fns[i] = fn
+	}
+	return fns, nil
+}
+
+// hostCall runs the host function called by the op at p.pos and returns the
+// change in bytes held by the slots it assigned.
+func (p *Machine) hostCall(fns []HostFunc) (int, error) {
+	index := p.OpAddrs[p.pos+1]
+	info := &p.Hosts[index]
+	args := make([]Value, len(info.In))
+	for i, typ := range info.In {
+		args[i] = p.valueAt(typ, p.OpAddrs[p.pos+2+i])
+	}
+	results, err := fns[index](args)
+	if err != nil {
+		return 0, p.hostErr(info.Name, err, "")
+	}
+	if len(results) != len(info.Out) {
+		return 0, p.hostErr(info.Name, ErrHostResult, "expected "+strconv.Itoa(len(info.Out))+" values, got "+strconv.Itoa(len(results)))
+	}
+	for i, typ := range info.Out {
+		if results[i].Type != typ {
+			return 0, p.hostErr(info.Name, ErrHostResult, "value "+strconv.Itoa(i+1)+" is "+results[i].Type.String()+", expected "+typ.String())
+		}
+	}
+	var delta int
+	outs := p.pos + 2 + len(info.In)
+	for i, typ := range info.Out {
+		delta += p.setValueAt(typ, p.OpAddrs[outs+i], results[i])
+	}
+	p.pos = outs + len(info.Out)
+	return delta, nil
+}
+
+// hostErr reports a failed host call under the host function's name rather
+// than that of the hostcall opcode.
+func (p *Machine) hostErr(name string, err error, detail string) error {
+	rerr := p.runtimeErr(err, detail).(*RuntimeError)
+	rerr.Op = name
+	return rerr
+}
+
+func (p *Bytecode) valueAt(typ Type, addr int) Value {
+	switch typ {
+	case Int:
+		return IntValue(p.Ints[addr])
+	case Str:
+		return StrValue(p.Strs[addr])
+	case Bool:
+		return BoolValue(p.Bools[addr])
+	case Float:
+		return FloatValue(p.Floats[addr])
+	case ArrInt:
+		return IntArrValue(append([]int(nil), p.IntArrs[addr]...))
+	case ArrStr:
+		return StrArrValue(append([]string(nil), p.StrArrs[addr]...))
+	case ArrBool:
+		return BoolArrValue(append([]bool(nil), p.BoolArrs[addr]...))
+	}
+	return Value{}
+}
+
+// setValueAt stores v and returns the change in bytes held by the slot.
+func (p *Bytecode) setValueAt(typ Type, addr int, v Value) int {
+	switch typ {
+	case Int:
+		p.Ints[addr] = v.Int
+	case Str:
+		delta := len(v.Str) - len(p.Strs[addr])
+		p.Strs[addr] = v.Str
+		return delta
+	case Bool:
+		p.Bools[addr] = v.Bool
+	case Float:
+		p.Floats[addr] = v.Float
+	case ArrInt:
+		delta := 8 * (len(v.IntArr) - len(p.IntArrs[addr]))
+		p.IntArrs[addr] = append([]int(nil), v.IntArr...)
+		return delta
+	case ArrStr:
+		delta := strsSize(v.StrArr) - strsSize(p.StrArrs[addr])
+		p.StrArrs[addr] = append([]string(nil), v.StrArr...)
+		return delta
+	case ArrBool:
+		delta := len(v.BoolArr) - len(p.BoolArrs[addr])
+		p.BoolArrs[addr] = append([]bool(nil), v.BoolArr...)
+		return delta
+	}
+	return 0
+}
@@ -0,0 +1,527 @@
+package ez
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// inference is the state of type inference for the identifiers of one scope.
+// An identifier whose type is not known yet belongs to a typeGroup and has no
+// slot; the ops that use it are emitted with placeholders that are patched
+// once the group's type is decided. Every group with a use must be decided by
+// the end of its scope.
+type inference struct {
+	groups    map[string]*typeGroup
+	overloads []*overload
+}
+
+// typeGroup is a set of undecided identifiers that must share a type, such
+// as both sides of a copy. array is set once the type is known to be an
+// array of some element type. line and span locate the first use of any of
+// them.
+type typeGroup struct {
+	ids       []string
+	array     bool
+	operands  []operandFixup
+	copies    []int // positions of copy opcodes
+	clears    []int // positions of array clear opcodes
+	overloads []*overload
+	line      uint16
+	span      [2]int
+}
+
+// operandFixup is an OpAddrs position that receives the slot of id.
+type operandFixup struct {
+	pos int
+	id  string
+}
+
+// overload is an op whose function is chosen among funcs once the types of
+// its operands, its arguments then its assignments, narrow them to one.
+type overload struct {
+	op       string
+	pos      int
+	funcs    []Func
+	operands []string
+	line     uint16
+	span     [2]int
+	done     bool
+}
+
+func newInference() *inference {
+	return &inference{groups: map[string]*typeGroup{}}
+}
+
+// clone returns a copy of inf that changes to either of them leave the
+// other as it was.
+func (inf *inference) clone() *inference {
+	c := &inference{groups: make(map[string]*typeGroup, len(inf.groups))}
+	overloads := map[*overload]*overload{}
+	cloneOverload := func(ov *overload) *overload {
+		if overloads[ov] == nil {
+			copied := *ov
+			overloads[ov] = &copied
+		}
+		return overloads[ov]
+	}
+	groups := map[*typeGroup]*typeGroup{}
+	for id, g := range inf.groups {
+		if groups[g] == nil {
+			copied := *g
+			copied.overloads = make([]*overload, len(g.overloads))
+			for i, ov := range g.overloads {
+				copied.overloads[i] = cloneOverload(ov)
+			}
+			groups[g] = &copied
+		}
+		c.groups[id] = groups[g]
+	}
+	for _, ov := range inf.overloads {
+		c.overloads = append(c.overloads, cloneOverload(ov))
+	}
+	return c
+}
+
+func isUndecided(typ Type) bool {
+	return typ == Und || typ == ArrUnd
+}
+
+// emitOperand appends the slot of id, or a placeholder for it if its type is
+// undecided.
+func (p *Parser) emitOperand(id string) {
+	if g := p.infer.groups[id]; g != nil {
+		p.used(g)
+		g.operands = append(g.operands, operandFixup{pos: len(p.bc.OpAddrs), id: id})
+		p.bc.OpAddrs = append(p.bc.OpAddrs, -1)
+		return
+	}
+	_, addr, _ := p.typeAndAddrOfID(id)
+	p.bc.OpAddrs = append(p.bc.OpAddrs, addr)
+}
+
+// emitCopy appends the op copying src to dst, which have the same type.
+func (p *Parser) emitCopy(src, dst string) {
+	if g := p.infer.groups[src]; g != nil {
+		p.used(g)
+		g.copies = append(g.copies, len(p.bc.OpAddrs))
+		p.bc.OpAddrs = append(p.bc.OpAddrs, -1)
+	} else {
+		typ, _, _ := p.typeAndAddrOfID(src)
+		p.bc.OpAddrs = append(p.bc.OpAddrs, copyInstructionForType(typ))
+	}
+	p.emitOperand(src)
+	p.emitOperand(dst)
+}
+
+// emitClear appends the op emptying the array id.
+func (p *Parser) emitClear(id string) {
+	if g := p.infer.groups[id]; g != nil {
+		p.used(g)
+		g.clears = append(g.clears, len(p.bc.OpAddrs))
+		p.bc.OpAddrs = append(p.bc.OpAddrs, -1)
+	} else {
+		typ, _, _ := p.typeAndAddrOfID(id)
+		p.bc.OpAddrs = append(p.bc.OpAddrs, arrClearInstructionForType(typ))
+	}
+	p.emitOperand(id)
+}
+
+func (p *Parser) used(g *typeGroup) {
+	if g.line == 0 {
+		g.line, g.span = p.line, p.span
+	}
+}
+
+// emitOverload appends an op calling one of funcs, all of which fit its
+// operands, and chooses the function if only one does. Otherwise the choice
+// waits for the types of the undecided operands.
+func (p *Parser) emitOverload(op string, funcs []Func, operands []string) error {
+	ov := &overload{op: op, pos: len(p.bc.OpAddrs), funcs: funcs, operands: operands, line: p.line, span: p.span}
+	p.bc.OpAddrs = append(p.bc.OpAddrs, -1)
+	switch funcs[0].addr {
+	case iopCall:
+		p.bc.OpAddrs = append(p.bc.OpAddrs, funcs[0].fnIndex)
+	case iopHostCall:
+		p.bc.OpAddrs = append(p.bc.OpAddrs, -1)
+	}
+	for _, id := range operands {
+		p.emitOperand(id)
+	}
+	if len(funcs) == 1 {
+		return p.resolve(ov, funcs[0])
+	}
+	p.infer.overloads = append(p.infer.overloads, ov)
+	for _, id := range operands {
+		if g := p.infer.groups[id]; g != nil {
+			g.overloads = append(g.overloads, ov)
+		}
+	}
+	return nil
+}
+
+// fits reports whether fun can take operands with the types known so far.
+// Operands not yet assigned fit anything, and the operands of one group
+// must be given the same type.
+func (p *Parser) fits(operands []string, fun Func) bool {
+	decided := map[*typeGroup]Type{}
+	for i, id := range operands {
+		want := operandType(fun, i)
+		info, found := p.IDInfo[id]
+		if !found || want == Und {
+			continue
+		}
+		g := p.infer.groups[id]
+		if g == nil {
+			if info.Type != want {
+				return false
+			}
+			continue
+		}
+		if g.array && !isArray(want) {
+			return false
+		}
+		if typ, ok := decided[g]; ok && typ != want {
+			return false
+		}
+		decided[g] = want
+	}
+	return true
+}
+
+// operandType returns the type fun takes or assigns at operand i.
+func operandType(fun Func, i int) Type {
+	if i < len(fun.In) {
+		return fun.In[i]
+	}
+	return fun.Out[i-len(fun.In)]
+}
+
+// resolve chooses fun for ov and decides its undecided operands to match.
+func (p *Parser) resolve(ov *overload, fun Func) error {
+	ov.done = true
+	p.bc.OpAddrs[ov.pos] = fun.addr
+	if fun.addr == iopHostCall {
+		p.bc.OpAddrs[ov.pos+1] = p.hostIndex(ov.op, fun)
+	}
+	for i, id := range ov.operands {
+		if err := p.decide(id, operandType(fun, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// narrow drops the functions of ov that no longer fit its operands, and
+// resolves it once one is left.
+func (p *Parser) narrow(ov *overload) error {
+	if ov.done {
+		return nil
+	}
+	var funcs []Func
+	for _, fun := range ov.funcs {
+		if p.fits(ov.operands, fun) {
+			funcs = append(funcs, fun)
+		}
+	}
+	switch len(funcs) {
+	case 0:
+		return p.parsingErr(CodeTypeMismatch, "conflicting types - no signature of '"+ov.op+"' on line "+strconv.Itoa(int(ov.line))+" fits the types inferred for its operands").withHint(signaturesHint(ov.op, ov.funcs))
+	case 1:
+		return p.resolve(ov, funcs[0])
+	}
+	ov.funcs = funcs
+	return nil
+}
+
+// decide gives every identifier in the group of id the type typ: it
+// allocates their slots, patches the ops emitted for them and narrows the
+// overloads they take part in. If id was decided already, even by a
+// decision this one set off, its type must be typ.
+func (p *Parser) decide(id string, typ Type) error {
+	g := p.infer.groups[id]
+	if g == nil {
+		if idTyp, _, _ := p.typeAndAddrOfID(id); idTyp != typ {
+			p.narrowSpan(id)
+			return p.parsingErr(CodeTypeMismatch, "conflicting types - '"+id+"' is inferred to be both "+idTyp.String()+" and "+typ.String())
+		}
+		return nil
+	}
+	if isUndecided(typ) {
+		// Not reached while every function an overload offers has decided
+		// types, which checkRecursiveCall and RegisterFunc see to.
+		msg := "unable to infer type of '" + id + "'"
+		if g.line == 0 {
+			return p.parsingErr(CodeInference, msg)
+		}
+		return p.errAt(g.line, g.span, CodeInference, msg)
+	}
+	for _, member := range g.ids {
+		delete(p.infer.groups, member)
+		line := p.IDInfo[member].Addresses[0].Line
+		addr := p.newAlloc(member, typ)
+		p.IDInfo[member].Addresses[0].Line = line
+		if param, ok := p.InParams[member]; ok && p.fn == nil {
+			param.Type, param.Addr = typ, addr
+			p.InParams[member] = param
+		}
+	}
+	for _, fix := range g.operands {
+		_, p.bc.OpAddrs[fix.pos], _ = p.typeAndAddrOfID(fix.id)
+	}
+	for _, pos := range g.copies {
+		p.bc.OpAddrs[pos] = copyInstructionForType(typ)
+	}
+	for _, pos := range g.clears {
+		p.bc.OpAddrs[pos] = arrClearInstructionForType(typ)
+	}
+	return p.narrowAll(g.overloads)
+}
+
+func (p *Parser) narrowAll(overloads []*overload) error {
+	for _, ov := range overloads {
+		if err := p.narrow(ov); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unify puts the undecided identifiers a and b in one group.
+func (p *Parser) unify(a, b string) error {
+	ga, gb := p.infer.groups[a], p.infer.groups[b]
+	if ga == gb {
+		return nil
+	}
+	if len(ga.ids) < len(gb.ids) {
+		ga, gb = gb, ga
+	}
+	for _, id := range gb.ids {
+		p.infer.groups[id] = ga
+	}
+	ga.ids = append(ga.ids, gb.ids...)
+	ga.operands = append(ga.operands, gb.operands...)
+	ga.copies = append(ga.copies, gb.copies...)
+	ga.clears = append(ga.clears, gb.clears...)
+	ga.overloads = append(ga.overloads, gb.overloads...)
+	if ga.line == 0 || gb.line != 0 && gb.line < ga.line {
+		ga.line, ga.span = gb.line, gb.span
+	}
+	if ga.array != gb.array {
+		return p.markArray(ga.ids[0])
+	}
+	return p.narrowAll(ga.overloads)
+}
+
+// markArray records that the undecided id holds an array.
+func (p *Parser) markArray(id string) error {
+	g := p.infer.groups[id]
+	g.array = true
+	for _, member := range g.ids {
+		info := p.IDInfo[member]
+		info.Type = ArrUnd
+		p.IDInfo[member] = info
+	}
+	return p.narrowAll(g.overloads)
+}
+
+// sameType makes the identifiers a and b, either of which may be undecided,
+// have the same type. It reports false if their types differ.
+func (p *Parser) sameType(a, b string) (bool, error) {
+	ta, _, _ := p.typeAndAddrOfID(a)
+	tb, _, _ := p.typeAndAddrOfID(b)
+	switch {
+	case isUndecided(ta) && isUndecided(tb):
+		return true, p.unify(a, b)
+	case isUndecided(ta):
+		return p.sameAsType(a, tb)
+	case isUndecided(tb):
+		return p.sameAsType(b, ta)
+	}
+	return ta == tb, nil
+}
+
+// sameAsType decides the type of id to be typ if it is undecided. It
+// reports false if id cannot have type typ.
+func (p *Parser) sameAsType(id string, typ Type) (bool, error) {
+	idTyp, _, _ := p.typeAndAddrOfID(id)
+	switch {
+	case !isUndecided(idTyp):
+		return idTyp == typ, nil
+	case typ == ArrUnd:
+		return true, p.markArray(id)
+	case idTyp == ArrUnd && !isArray(typ):
+		return false, nil
+	}
+	return true, p.decide(id, typ)
+}
+
+// paramTypesHint explains the errors that come of a function's parameter
+// types being decided by its body alone.
+const paramTypesHint = "parameter types come from how the body of a function uses them, not from its calls"
+
+// inferTypes settles the types of the current scope at its end. Overloads
+// left open only between int and float versions, as in 'a * b' of two
+// parameters, take the int ones, int being the type of number literals
+// without a fraction. Any other overload still open is ambiguous, and any
+// identifier still undecided that an op uses is an error.
+//
+// The parameters of a function are settled this way at its 'end', from its
+// body alone: the calls after it are compiled against the types it ended
+// with, so 'mul 1.5 2.0' of a 'mul' that defaulted to int fails.
+func (p *Parser) inferTypes() error {
+	for changed := true; changed; {
+		changed = false
+		for _, ov := range p.infer.overloads {
+			if fun, ok := p.intVersion(ov); ok {
+				p.noteIntDefault(ov)
+				// A conflict this sets off is reported at ov, not at the
+				// line ending the scope.
+				line, span, fields := p.line, p.span, p.fields
+				p.line, p.span, p.fields = ov.line, ov.span, nil
+				err := p.resolve(ov, fun)
+				p.line, p.span, p.fields = line, span, fields
+				if err != nil {
+					return err
+				}
+				changed = true
+			}
+		}
+	}
+	for _, ov := range p.infer.overloads {
+		if !ov.done {
+			return p.ambiguousErr(ov)
+		}
+	}
+	p.infer.overloads = nil
+
+	var used []*typeGroup
+	seen := map[*typeGroup]bool{}
+	for _, g := range p.infer.groups {
+		if !seen[g] && g.line != 0 {
+			used = append(used, g)
+		}
+		seen[g] = true
+	}
+	if len(used) == 0 {
+		return nil
+	}
+	sort.Slice(used, func(i, j int) bool {
+		if used[i].line != used[j].line {
+			return used[i].line < used[j].line
+		}
+		return used[i].ids[0] < used[j].ids[0]
+	})
+	g := used[0]
+	id := g.ids[0]
+	msg := "unable to infer type of '" + id + "'"
+	switch {
+	case p.fn != nil && p.isParam(id):
+		msg = "unable to infer type of parameter '" + id + "' of '" + p.fn.name + "'"
+		return p.errAt(g.line, g.span, CodeInference, msg).withHint(paramTypesHint)
+	case g.array:
+		msg = "unable to infer element type of array '" + id + "'"
+	}
+	return p.errAt(g.line, g.span, CodeInference, msg)
+}
+
+// noteIntDefault records the parameters of the function being parsed that
+// defaulting ov to its int version decides, for the errors of calls that
+// pass them floats.
+func (p *Parser) noteIntDefault(ov *overload) {
+	if p.fn == nil {
+		return
+	}
+	for _, param := range p.fn.params {
+		g := p.infer.groups[param]
+		if g == nil || slices.Contains(p.fn.ints.params, param) {
+			continue
+		}
+		for _, id := range ov.operands {
+			if p.infer.groups[id] == g {
+				p.fn.ints.params = append(p.fn.ints.params, param)
+				if p.fn.ints.line == 0 {
+					p.fn.ints.line = ov.line
+				}
+				break
+			}
+		}
+	}
+}
+
+// describe tells which parameters of the function fn were defaulted to int.
+func (ints intDefault) describe(fn string) string {
+	names := "parameter '" + ints.params[0] + "'"
+	if n := len(ints.params); n > 1 {
+		names = "parameters '" + strings.Join(ints.params[:n-1], "', '") + "' and '" + ints.params[n-1] + "'"
+	}
+	return names + " of '" + fn + "' defaulted to int, as line " + strconv.Itoa(int(ints.line)) + " takes int or float for them"
+}
+
+// intVersion returns the function of an open overload to default to, the
+// one taking int for every undecided operand, if all of its functions
+// differ only in taking int or float for them.
+func (p *Parser) intVersion(ov *overload) (Func, bool) {
+	if ov.done {
+		return Func{}, false
+	}
+	var found *Func
+	for i, fun := range ov.funcs {
+		allInt := true
+		for j, id := range ov.operands {
+			if p.infer.groups[id] == nil {
+				continue
+			}
+			switch operandType(fun, j) {
+			case Int:
+			case Float:
+				allInt = false
+			default:
+				return Func{}, false
+			}
+		}
+		if allInt {
+			found = &ov.funcs[i]
+		}
+	}
+	if found == nil {
+		return Func{}, false
+	}
+	return *found, true
+}
+
+func (p *Parser) ambiguousErr(ov *overload) error {
+	var id string
+	for _, operand := range ov.operands {
+		if p.infer.groups[operand] != nil {
+			id = operand
+			break
+		}
+	}
+	msg := "ambiguous call to '" + ov.op + "' - unable to infer type of '" + id + "'"
+	if isTemp(id) {
+		msg = "ambiguous call to '" + ov.op + "' - unable to infer the types of its operands"
+	}
+	diag := p.errAt(ov.line, ov.span, CodeInference, msg)
+	return diag.withHint(signaturesHint(ov.op, ov.funcs))
+}
+
+func (p *Parser) isParam(id string) bool {
+	for _, param := range p.fn.params {
+		if param == id {
+			return true
+		}
+	}
+	return false
+}
+
+// errAt returns an error at line and span rather than at the statement being
+// parsed.
+func (p *Parser) errAt(line uint16, span [2]int, code, msg string) *Diagnostic {
+	curLine, curSpan := p.line, p.span
+	p.line, p.span = line, span
+	diag := p.parsingErr(code, msg)
+	p.line, p.span = curLine, curSpan
+	return diag
+}
@@ -0,0 +1,65 @@
+package ez
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInferenceErrorPositions(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		line      uint16
+		col       int
+		code, msg string
+	}{
+		{
+			name: "defaulted op conflicting with a later one",
+			src:  "func f a b\n  c = a * b\n  d = len a\n  return c d\nend\n",
+			line: 2, col: 3, code: CodeTypeMismatch,
+			msg: "no signature of 'len' on line 3",
+		},
+		{
+			name: "unused parameter",
+			src:  "a = 1\nfunc f x\n  print 3\nend\n",
+			line: 2, col: 8, code: CodeInference,
+			msg: "unable to infer type of parameter 'x' of 'f'",
+		},
+		{
+			name: "use conflicting with an earlier line",
+			src:  "func f a\n  b = a * 2\n  c = a + 'x'\n  return b\nend\n",
+			line: 3, col: 3, code: CodeNoSignature,
+			msg: "'+'",
+		},
+		{
+			name: "parameter only returned",
+			src:  "func id a\n  return a\nend\nx = id 1\n",
+			line: 2, col: 3, code: CodeInference,
+			msg: "unable to infer type of parameter 'a' of 'id'",
+		},
+		{
+			name: "parameter only copied",
+			src:  "func f a\n  b = a\n  return b\nend\nx = f 1\n",
+			line: 2, col: 3, code: CodeInference,
+			msg: "unable to infer type of parameter 'a' of 'f'",
+		},
+		{
+			name: "floats passed to parameters defaulted to int",
+			src:  "func mul a b\n  c = a * b\n  return c\nend\nx = mul 1.5 2.0\n",
+			line: 5, col: 1, code: CodeNoSignature,
+			msg: "parameters 'a' and 'b' of 'mul' defaulted to int, as line 2 takes int or float",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, diags := ParseDiagnostics(strings.NewReader(test.src))
+			if len(diags) == 0 {
+				t.Fatal("no diagnostics")
+			}
+			d := diags[0]
+			if d.Line != test.line || d.Col != test.col || d.Code != test.code || !strings.Contains(d.Message, test.msg) {
+				t.Errorf("got %s %d:%d %q, want %s %d:%d containing %q", d.Code, d.Line, d.Col, d.Message, test.code, test.line, test.col, test.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package ez
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotSuspended = errors.New("machine is not suspended")
+
+// Status tells how the last run of a Machine ended.
+type Status int
+
+const (
+	Ready     Status = iota // not run since it was made or Reset
+	Suspended               // stopped by a yield, to be resumed
+	Finished                // ran to the end of its bytecode
+	Failed                  // stopped by an error
+)
+
+func (s Status) String() string {
+	switch s {
+	case Ready:
+		return "ready"
+	case Suspended:
+		return "suspended"
+	case Finished:
+		return "finished"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Machine runs a Bytecode, which it only reads, so one Bytecode can be run
+// by any number of machines at once. A machine holds everything a run
+// changes: the position of the next op, the call stack and the values of the
+// slots. Its embedded Bytecode holds those values; pools that no op writes
+// are shared with the bytecode run and must not be modified.
+type Machine struct {
+	Bytecode
+	code     *Bytecode
+	owned    poolSet // pools with values of the machine's own
+	opStarts []bool
+	hosts    []HostFunc // bound against hostEnv
+	hostEnv  *Env
+	pos      int
+	stack    []callFrame
+	status   Status
+	in, out  map[string]Param
+	env      *Env
+}
+
+// poolSet holds a flag for each pool, indexed by its Type.
+type poolSet [Float + 1]bool
+
+// NewMachine returns a machine ready to run bc from its first op.
+func NewMachine(bc *Bytecode) *Machine {
+	m := &Machine{code: bc, owned: bc.writtenPools()}
+	m.Reset()
+	return m
+}
+
+// NewMachine returns a machine ready to run the program. Set its inputs with
+// SetInputs and read its outputs with Outputs once it has run.
+func (prog *Program) NewMachine() *Machine {
+	var m *Machine
+	if prog.opStarts == nil {
+		m = NewMachine(&prog.Bytecode) // not compiled, or failing to verify
+	} else {
+		m = &Machine{code: &prog.Bytecode, owned: prog.written, opStarts: prog.opStarts, hosts: prog.hosts, hostEnv: prog.env}
+		m.Reset()
+	}
+	m.in, m.out, m.env = prog.InParams, prog.OutParams, prog.env
+	return m
+}
+
+// Reset returns m to the start of its bytecode with every slot as compiled,
+// reusing the memory of the previous run.
+func (m *Machine) Reset() {
+	code := m.code
+	m.OpAddrs, m.Lines, m.Names, m.Funcs, m.Hosts = code.OpAddrs, code.Lines, code.Names, code.Funcs, code.Hosts
+	m.Ints = loadPool(m.Ints, code.Ints, m.owned[Int])
+	m.Strs = loadPool(m.Strs, code.Strs, m.owned[Str])
+	m.Bools = loadPool(m.Bools, code.Bools, m.owned[Bool])
+	m.Floats = loadPool(m.Floats, code.Floats, m.owned[Float])
+	m.IntArrs = loadArrays(m.IntArrs, code.IntArrs)
+	m.StrArrs = loadArrays(m.StrArrs, code.StrArrs)
+	m.BoolArrs = loadArrays(m.BoolArrs, code.BoolArrs)
+	m.pos = 0
+	m.stack = m.stack[:0]
+	m.status = Ready
+}
+
+// Run runs m from where it stopped until the end of its bytecode or a yield,
+// which leaves m Suspended. The Env of the Program the machine was made for
+// is the default for opts.Env.
+func (m *Machine) Run(ctx context.Context, opts Options) error {
+	if opts.Env == nil {
+		opts.Env = m.env
+	}
+	err := m.run(ctx, opts)
+	switch {
+	case err != nil && !errors.Is(err, errPaused):
+		m.status = Failed
+	case m.Done():
+		m.status = Finished
+	default:
+		m.status = Suspended
+	}
+	return err
+}
+
+// Resume continues a Suspended machine from the op after its yield, with
+// every slot and call as the yield left them.
+func (m *Machine) Resume(ctx context.Context, opts Options) error {
+	if m.status != Suspended {
+		return ErrNotSuspended
+	}
+	return m.Run(ctx, opts)
+}
+
+func (m *Machine) Status() Status {
+	return m.status
+}
+
+// finished reports a machine stopped at a yield as failing with
+// ErrSuspended, for callers that have no way to resume it.
+func (m *Machine) finished() error {
+	if m.status != Suspended {
+		return nil
+	}
+	return m.runtimeErrAt(m.pos-1, ErrSuspended, "run the script on a Machine to resume it")
+}
+
+// Done reports whether m has run to the end of its bytecode.
+func (m *Machine) Done() bool {
+	return m.pos >= len(m.OpAddrs)
+}
+
+// SetInputs sets the in parameters of the program m was made for. Every one
+// of them must be given.
+func (m *Machine) SetInputs(inputs map[string]any) error {
+	for id := range inputs {
+		if _, ok := m.in[id]; !ok {
+			return errors.New("unknown in parameter: '" + id + "'")
+		}
+	}
+	for id, param := range m.in {
+		val, ok := inputs[id]
+		if !ok {
+			return errors.New("missing in parameter: '" + id + "'")
+		}
+		if err := m.setParam(id, param, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Outputs returns the current values of the out parameters of the program m
+// was made for.
+func (m *Machine) Outputs() map[string]any {
+	outputs := make(map[string]any, len(m.out))
+	for id, param := range m.out {
+		if !isValueType(param.Type) {
+			continue
+		}
+		outputs[id] = m.valueAt(param.Type, param.Addr).Any()
+	}
+	return outputs
+}
+
+// own gives m its own copy of the pool of typ, so that it may be written.
+func (m *Machine) own(typ Type) {
+	if m.owned[typ] {
+		return
+	}
+	m.owned[typ] = true
+	switch typ {
+	case Int:
+		m.Ints = append([]int(nil), m.Ints...)
+	case Str:
+		m.Strs = append([]string(nil), m.Strs...)
+	case Bool:
+		m.Bools = append([]bool(nil), m.Bools...)
+	case Float:
+		m.Floats = append([]float64(nil), m.Floats...)
+	}
+}
+
+// attach makes m run code in place, on code's own pools, from where it
+// stopped. A session attaches its machine again after every line compiled,
+// since the parser appends to the pools and patches jump slots of earlier
+// lines.
+func (m *Machine) attach(code *Bytecode) {
+	m.code = code
+	m.Bytecode = *code
+	m.opStarts = nil
+	m.hosts, m.hostEnv = nil, nil
+}
+
+// writtenPools returns the scalar pools a run of bc may write: those of the
+// slots ops assign, of function parameters and of function frames, which
+// returns restore. Array pools are always copied, since ops change arrays
+// in place.
+func (bc *Bytecode) writtenPools() poolSet {
+	var written poolSet
+	written[ArrInt], written[ArrStr], written[ArrBool] = true, true, true
+	for pos := 0; pos < len(bc.OpAddrs); {
+		args, ok := bc.opArgs(pos)
+		if !ok || pos+len(args) >= len(bc.OpAddrs) {
+			break // left for verify to report
+		}
+		for _, typ := range args[len(args)-bc.opOuts(pos):] {
+			written[poolKey(typ, 0).typ] = true
+		}
+		pos += 1 + len(args)
+	}
+	for _, fn := range bc.Funcs {
+		for _, typ := range fn.In {
+			written[typ] = true
+		}
+		for i, r := range fn.Frame.ranges() {
+			if r.End > r.Start {
+				written[framePools[i]] = true
+			}
+		}
+	}
+	return written
+}
+
+// framePools lists the types of the pools in the order of Frame.ranges.
+var framePools = []Type{Int, Str, Bool, ArrInt, ArrStr, ArrBool, Float}
+
+// loadPool returns the values of pool for a new run, in buf if the machine
+// owns it and shared with the bytecode if not.
+func loadPool[T any](buf, pool []T, owned bool) []T {
+	if !owned {
+		return pool
+	}
+	return append(buf[:0], pool...)
+}
+
+// loadArrays returns a copy of every array in pool, reusing buf.
+func loadArrays[T any](buf, pool [][]T) [][]T {
+	buf = buf[:0]
+	for _, arr := range pool {
+		buf = append(buf, append([]T(nil), arr...))
+	}
+	return buf
+}
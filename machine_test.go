@@ -0,0 +1,72 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const yieldSrc = `n
+out total
+total = 0
+for i from 1 to n
+  total = total + i
+  print total
+  yield
+end
+`
+
+func TestMachineYield(t *testing.T) {
+	prog, err := Compile(strings.NewReader(yieldSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	inputs := map[string]any{"n": 3}
+
+	// Without a machine to resume, a yield ends the run with an error.
+	_, err = prog.ExecWithOptions(ctx, inputs, Options{Output: new(bytes.Buffer)})
+	checkRuntimeErr(t, err, runtimeErrTest{err: ErrSuspended, line: 7, detail: "run the script on a Machine to resume it"})
+
+	var out bytes.Buffer
+	m := prog.NewMachine()
+	if err := m.SetInputs(inputs); err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != Ready {
+		t.Fatalf("new machine is %v", m.Status())
+	}
+	if err := m.Resume(ctx, Options{Output: &out}); !errors.Is(err, ErrNotSuspended) {
+		t.Fatalf("resuming a ready machine: error %v, want ErrNotSuspended", err)
+	}
+	err = m.Run(ctx, Options{Output: &out})
+	for _, want := range []string{"1\n", "1\n3\n", "1\n3\n6\n"} {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Status() != Suspended || m.Done() {
+			t.Fatalf("machine is %v after printing %q, want suspended", m.Status(), out.String())
+		}
+		if out.String() != want {
+			t.Fatalf("printed %q before yielding, want %q", out.String(), want)
+		}
+		if err := m.finished(); !errors.Is(err, ErrSuspended) {
+			t.Errorf("finishing a suspended machine: error %v, want ErrSuspended", err)
+		}
+		err = m.Resume(ctx, Options{Output: &out})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Status() != Finished || !m.Done() {
+		t.Fatalf("machine is %v after the last resume, want finished", m.Status())
+	}
+	if got := m.Outputs()["total"]; got != 6 {
+		t.Errorf("total = %v, want 6", got)
+	}
+	if err := m.Resume(ctx, Options{Output: &out}); !errors.Is(err, ErrNotSuspended) {
+		t.Errorf("resuming a finished machine: error %v, want ErrNotSuspended", err)
+	}
+}
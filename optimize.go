@@ -0,0 +1,635 @@
+package ez
+
+import (
+	"context"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Optimize rewrites bc to do the same work with fewer ops and slots. Ops
+// whose inputs are all constants are folded, equal constants share a slot,
+// copies that the op before them could have done are dropped, as is code no
+// jump can reach, and the slots left unused are removed.
+//
+// A slot that no op writes is taken for a constant, so bytecode whose in
+// parameters are set from outside must be optimized with Program.Optimize,
+// which keeps them. Named slots are kept for the debugger and disassembler.
+func Optimize(bc *Bytecode) error {
+	_, err := optimize(bc, nil)
+	return err
+}
+
+// Optimize is like the Optimize function but keeps the slots of the
+// program's in and out parameters.
+func (prog *Program) Optimize() error {
+	params := []map[string]Param{prog.InParams, prog.OutParams}
+	var pinned []slotKey
+	for _, ps := range params {
+		for _, param := range ps {
+			if param.Type != Und {
+				pinned = append(pinned, slotKey{param.Type, param.Addr})
+			}
+		}
+	}
+	remap, err := optimize(&prog.Bytecode, pinned)
+	if err != nil {
+		return err
+	}
+	for _, ps := range params {
+		for id, param := range ps {
+			if param.Type != Und {
+				param.Addr = remap(param.Type, param.Addr)
+				ps[id] = param
+			}
+		}
+	}
+	prog.prepare()
+	return nil
+}
+
+// optInst is a decoded op. Jump operands and position slots hold positions
+// from before optimizing until the ops are encoded again.
+type optInst struct {
+	op   int
+	args []Type
+	ops  []int
+	outs int
+	dead bool
+}
+
+type optimizer struct {
+	bc       *Bytecode
+	insts    []optInst
+	index    map[int]int // position before optimizing to index in insts
+	pinned   map[slotKey]bool
+	named    map[slotKey]bool
+	params   map[slotKey]bool
+	posSlots map[int]bool // Ints slots holding positions
+
+	// Set by analyze from the live insts.
+	writers map[slotKey][]int
+	readers map[slotKey][]int
+	targets []bool // whether a jump can land on each inst
+}
+
+// optimize optimizes bc, keeping the pinned slots, and returns how slots
+// were renumbered.
+func optimize(bc *Bytecode, pinned []slotKey) (func(Type, int) int, error) {
+	if _, err := verify(bc); err != nil {
+		return nil, err
+	}
+	o := &optimizer{
+		bc:       bc,
+		index:    map[int]int{},
+		pinned:   map[slotKey]bool{},
+		named:    map[slotKey]bool{},
+		params:   map[slotKey]bool{},
+		posSlots: map[int]bool{},
+	}
+	for pos := 0; pos < len(bc.OpAddrs); {
+		args, _ := bc.opArgs(pos)
+		o.index[pos] = len(o.insts)
+		o.insts = append(o.insts, optInst{
+			op:   bc.OpAddrs[pos],
+			args: args,
+			ops:  append([]int(nil), bc.OpAddrs[pos+1:pos+1+len(args)]...),
+			outs: bc.opOuts(pos),
+		})
+		for i, typ := range args {
+			if typ == Addr {
+				o.posSlots[bc.OpAddrs[pos+1+i]] = true
+			}
+		}
+		pos += 1 + len(args)
+	}
+	o.index[len(bc.OpAddrs)] = len(o.insts)
+	for _, k := range pinned {
+		o.pinned[poolKey(k.typ, k.addr)] = true
+	}
+	for _, name := range bc.Names {
+		o.named[poolKey(name.Type, name.Slot)] = true
+		if name.Type == Addr {
+			o.posSlots[name.Slot] = true
+		}
+	}
+	for _, fn := range bc.Funcs {
+		for i, typ := range fn.In {
+			o.params[poolKey(typ, fn.Params[i])] = true
+		}
+	}
+	passes := []func() bool{o.fold, o.propagate, o.retarget, o.dropDeadStores, o.dropUnreachable}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range passes {
+			o.analyze()
+			if pass() {
+				changed = true
+			}
+		}
+	}
+	o.analyze()
+	o.shareConstants()
+	remap := o.compact()
+	o.encode()
+	return remap, nil
+}
+
+// poolKey identifies a slot by its pool, Addr slots being Ints.
+func poolKey(typ Type, addr int) slotKey {
+	if typ == Addr {
+		typ = Int
+	}
+	return slotKey{typ, addr}
+}
+
+// operandKey returns the slot of an operand, false if it is not one.
+func operandKey(typ Type, addr int) (slotKey, bool) {
+	if typ == funcRef || typ == hostRef || typ == jumpPos {
+		return slotKey{}, false
+	}
+	return poolKey(typ, addr), true
+}
+
+func (o *optimizer) analyze() {
+	o.writers = map[slotKey][]int{}
+	o.readers = map[slotKey][]int{}
+	o.targets = make([]bool, len(o.insts)+1)
+	for i, inst := range o.insts {
+		if inst.dead {
+			continue
+		}
+		for j, typ := range inst.args {
+			if typ == jumpPos {
+				o.markTarget(inst.ops[j])
+			}
+			k, ok := operandKey(typ, inst.ops[j])
+			if !ok {
+				continue
+			}
+			if j >= len(inst.args)-inst.outs {
+				o.writers[k] = append(o.writers[k], i)
+			} else {
+				o.readers[k] = append(o.readers[k], i)
+			}
+			if typ == Addr {
+				o.markTarget(o.bc.Ints[inst.ops[j]])
+			}
+		}
+	}
+	for _, fn := range o.bc.Funcs {
+		o.markTarget(fn.Entry)
+	}
+}
+
+// markTarget records that a jump lands on the op at pos, which is the next
+// live one if the op there has been dropped.
+func (o *optimizer) markTarget(pos int) {
+	if i, ok := o.index[pos]; ok {
+		o.targets[o.live(i)] = true
+	}
+}
+
+// live returns the index of the first live inst from i on.
+func (o *optimizer) live(i int) int {
+	for i < len(o.insts) && o.insts[i].dead {
+		i++
+	}
+	return i
+}
+
+// constant reports whether k is a scalar slot that always holds its initial
+// value.
+func (o *optimizer) constant(k slotKey) bool {
+	switch k.typ {
+	case Int, Str, Bool, Float:
+	default:
+		return false
+	}
+	return len(o.writers[k]) == 0 && !o.pinned[k] && !o.params[k] && !(k.typ == Int && o.posSlots[k.addr])
+}
+
+// hidden reports whether k is a slot that only the ops use, which may be
+// merged, redirected or removed.
+func (o *optimizer) hidden(k slotKey) bool {
+	return !o.pinned[k] && !o.named[k] && !o.params[k] && !(k.typ == Int && o.posSlots[k.addr])
+}
+
+func isCopy(op int) bool {
+	return opcodes[op].name == "copy"
+}
+
+// fold replaces ops whose inputs are all constants by a copy of the value
+// they compute, and ifs on a constant condition by a goto or nothing.
+func (o *optimizer) fold() bool {
+	changed := false
+	ifOp, gotoOp := baselibAddr("if", Bool), baselibAddr("goto", Addr)
+	for i := range o.insts {
+		inst := &o.insts[i]
+		if inst.dead {
+			continue
+		}
+		if inst.op == ifOp {
+			if !o.constant(slotKey{Bool, inst.ops[0]}) {
+				continue
+			}
+			if o.bc.Bools[inst.ops[0]] {
+				inst.dead = true
+			} else {
+				slot := len(o.bc.Ints)
+				o.bc.Ints = append(o.bc.Ints, inst.ops[1])
+				o.posSlots[slot] = true
+				*inst = optInst{op: gotoOp, args: opcodes[gotoOp].args, ops: []int{slot}}
+			}
+			changed = true
+			continue
+		}
+		if !o.foldable(inst) {
+			continue
+		}
+		val, ok := o.eval(inst)
+		if !ok {
+			continue
+		}
+		typ, out := inst.args[len(inst.args)-1], inst.ops[len(inst.ops)-1]
+		op := copyInstructionForType(typ)
+		*inst = optInst{op: op, args: opcodes[op].args, ops: []int{o.bc.appendSlot(typ, val), out}, outs: 1}
+		changed = true
+	}
+	return changed
+}
+
+// foldable reports whether inst computes a single scalar from constants.
+func (o *optimizer) foldable(inst *optInst) bool {
+	if inst.outs != 1 || isCopy(inst.op) {
+		return false
+	}
+	for j, typ := range inst.args {
+		switch typ {
+		case Int, Str, Bool, Float:
+		default:
+			return false
+		}
+		if j < len(inst.args)-1 && !o.constant(slotKey{typ, inst.ops[j]}) {
+			return false
+		}
+	}
+	return true
+}
+
+// eval runs inst on its own and returns the value it writes, false if it
+// fails, so that folding behaves exactly as the VM would.
+func (o *optimizer) eval(inst *optInst) (Value, bool) {
+	scratch := Bytecode{OpAddrs: []int{inst.op}}
+	for j, typ := range inst.args {
+		scratch.OpAddrs = append(scratch.OpAddrs, scratch.appendSlot(typ, o.bc.valueAt(typ, inst.ops[j])))
+	}
+	m := NewMachine(&scratch)
+	if err := m.Run(context.Background(), Options{MaxSteps: 1, Output: io.Discard}); err != nil {
+		return Value{}, false
+	}
+	out := len(inst.args) - 1
+	return m.valueAt(inst.args[out], scratch.OpAddrs[1+out]), true
+}
+
+// propagate makes the readers of a slot that only ever holds a copy of a
+// constant read the constant instead, if each of them runs straight after
+// the copy with no jump landing in between.
+func (o *optimizer) propagate() bool {
+	changed := false
+	for i, inst := range o.insts {
+		if inst.dead || !isCopy(inst.op) {
+			continue
+		}
+		src, dst := poolKey(inst.args[0], inst.ops[0]), poolKey(inst.args[1], inst.ops[1])
+		readers := o.readers[dst]
+		if !o.constant(src) || !o.hidden(dst) || len(o.writers[dst]) != 1 || len(readers) == 0 || readers[0] <= i {
+			continue
+		}
+		straight := true
+		for j := i + 1; j <= readers[len(readers)-1]; j++ {
+			if o.targets[j] {
+				straight = false
+				break
+			}
+		}
+		if !straight {
+			continue
+		}
+		for _, r := range readers {
+			reader := &o.insts[r]
+			for j := 0; j < len(reader.args)-reader.outs; j++ {
+				if k, ok := operandKey(reader.args[j], reader.ops[j]); ok && k == dst {
+					reader.ops[j] = src.addr
+				}
+			}
+		}
+		changed = true
+	}
+	return changed
+}
+
+// retarget lets an op write straight to the destination of the copy after
+// it, when the copied slot is used for nothing else.
+func (o *optimizer) retarget() bool {
+	changed := false
+	touched := map[slotKey]bool{}
+	prev := -1
+	for i := range o.insts {
+		c := &o.insts[i]
+		if c.dead {
+			continue
+		}
+		j := prev
+		prev = i
+		if j < 0 || !isCopy(c.op) || o.targets[i] {
+			continue
+		}
+		a := &o.insts[j]
+		t, x := poolKey(c.args[0], c.ops[0]), poolKey(c.args[1], c.ops[1])
+		if touched[t] || touched[x] || !o.hidden(t) || len(o.writers[t]) != 1 || o.writers[t][0] != j || len(o.readers[t]) != 1 {
+			continue
+		}
+		out := -1
+		for n, typ := range a.args {
+			k, ok := operandKey(typ, a.ops[n])
+			if ok && k == x {
+				out = -1
+				break
+			}
+			if ok && k == t {
+				out = n
+			}
+		}
+		if out < 0 {
+			continue
+		}
+		a.ops[out] = x.addr
+		c.dead = true
+		touched[t], touched[x] = true, true
+		prev = j
+		changed = true
+	}
+	return changed
+}
+
+// dropDeadStores removes copies to slots that are never read.
+func (o *optimizer) dropDeadStores() bool {
+	changed := false
+	for i := range o.insts {
+		inst := &o.insts[i]
+		if inst.dead || !isCopy(inst.op) {
+			continue
+		}
+		dst := poolKey(inst.args[1], inst.ops[1])
+		if inst.ops[0] == inst.ops[1] || o.hidden(dst) && len(o.readers[dst]) == 0 {
+			inst.dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// dropUnreachable removes gotos to the op right after them, and the ops
+// after a goto or return that no jump lands on.
+func (o *optimizer) dropUnreachable() bool {
+	changed := false
+	reachable := true
+	for i := range o.insts {
+		inst := &o.insts[i]
+		if inst.dead {
+			continue
+		}
+		if o.targets[i] {
+			reachable = true
+		}
+		if !reachable {
+			inst.dead = true
+			changed = true
+			continue
+		}
+		switch opcodes[inst.op].name {
+		case "goto":
+			if target, ok := o.index[o.bc.Ints[inst.ops[0]]]; ok && o.live(target) == o.live(i+1) {
+				inst.dead = true
+				changed = true
+			} else {
+				reachable = false
+			}
+		case "return", "missingreturn":
+			reachable = false
+		}
+	}
+	return changed
+}
+
+// shareConstants points the readers of equal hidden constants at the first
+// of them.
+func (o *optimizer) shareConstants() {
+	type constKey struct {
+		typ Type
+		val string
+	}
+	shared := map[constKey]int{}
+	for i := range o.insts {
+		inst := &o.insts[i]
+		if inst.dead {
+			continue
+		}
+		for j := 0; j < len(inst.args)-inst.outs; j++ {
+			k, ok := operandKey(inst.args[j], inst.ops[j])
+			if !ok || !o.constant(k) || !o.hidden(k) {
+				continue
+			}
+			var val string
+			switch k.typ {
+			case Int:
+				val = strconv.Itoa(o.bc.Ints[k.addr])
+			case Str:
+				val = o.bc.Strs[k.addr]
+			case Bool:
+				val = strconv.FormatBool(o.bc.Bools[k.addr])
+			case Float:
+				val = strconv.FormatUint(math.Float64bits(o.bc.Floats[k.addr]), 16)
+			}
+			if addr, ok := shared[constKey{k.typ, val}]; ok {
+				inst.ops[j] = addr
+			} else {
+				shared[constKey{k.typ, val}] = k.addr
+			}
+		}
+	}
+}
+
+// compact removes the hidden slots that no live op refers to and returns how
+// the remaining ones were renumbered.
+func (o *optimizer) compact() func(Type, int) int {
+	used := map[slotKey]bool{}
+	for _, kept := range []map[slotKey]bool{o.pinned, o.named, o.params} {
+		for k := range kept {
+			used[k] = true
+		}
+	}
+	for _, inst := range o.insts {
+		if inst.dead {
+			continue
+		}
+		for j, typ := range inst.args {
+			if k, ok := operandKey(typ, inst.ops[j]); ok {
+				used[k] = true
+			}
+		}
+	}
+	// before[typ][slot] counts the used slots of typ ahead of slot, which is
+	// the new index of a used slot and the new bound of a frame range.
+	before := map[Type][]int{}
+	for _, typ := range []Type{Int, Str, Bool, Float, ArrInt, ArrStr, ArrBool} {
+		n := o.bc.poolLen(typ)
+		counts := make([]int, n+1)
+		for slot := 0; slot < n; slot++ {
+			counts[slot+1] = counts[slot]
+			if used[slotKey{typ, slot}] {
+				counts[slot+1]++
+			}
+		}
+		before[typ] = counts
+	}
+	remap := func(typ Type, addr int) int {
+		return before[poolKey(typ, addr).typ][addr]
+	}
+
+	bc := o.bc
+	bc.Ints = keepSlots(bc.Ints, before[Int])
+	bc.Strs = keepSlots(bc.Strs, before[Str])
+	bc.Bools = keepSlots(bc.Bools, before[Bool])
+	bc.Floats = keepSlots(bc.Floats, before[Float])
+	bc.IntArrs = keepSlots(bc.IntArrs, before[ArrInt])
+	bc.StrArrs = keepSlots(bc.StrArrs, before[ArrStr])
+	bc.BoolArrs = keepSlots(bc.BoolArrs, before[ArrBool])
+	for i := range o.insts {
+		inst := &o.insts[i]
+		if inst.dead {
+			continue
+		}
+		for j, typ := range inst.args {
+			if _, ok := operandKey(typ, inst.ops[j]); ok {
+				inst.ops[j] = remap(typ, inst.ops[j])
+			}
+		}
+	}
+	posSlots := make(map[int]bool, len(o.posSlots))
+	for slot := range o.posSlots {
+		if used[slotKey{Int, slot}] {
+			posSlots[remap(Int, slot)] = true
+		}
+	}
+	o.posSlots = posSlots
+	funcs := make([]FuncInfo, len(bc.Funcs))
+	for i, fn := range bc.Funcs {
+		fn.Params = append([]int(nil), fn.Params...)
+		for j, typ := range fn.In {
+			fn.Params[j] = remap(typ, fn.Params[j])
+		}
+		frame := fn.Frame.rangePtrs()
+		for j, typ := range []Type{Int, Str, Bool, ArrInt, ArrStr, ArrBool, Float} {
+			frame[j].Start, frame[j].End = before[typ][frame[j].Start], before[typ][frame[j].End]
+		}
+		funcs[i] = fn
+	}
+	bc.Funcs = funcs
+	names := make([]SlotName, len(bc.Names))
+	for i, name := range bc.Names {
+		name.Slot = remap(name.Type, name.Slot)
+		names[i] = name
+	}
+	if bc.Names != nil {
+		bc.Names = names
+	}
+	return remap
+}
+
+// keepSlots returns the slots of pool that before counts as used.
+func keepSlots[T any](pool []T, before []int) []T {
+	if pool == nil {
+		return nil
+	}
+	kept := make([]T, 0, before[len(pool)])
+	for slot, val := range pool {
+		if before[slot+1] > before[slot] {
+			kept = append(kept, val)
+		}
+	}
+	return kept
+}
+
+// encode writes the live insts back to bc.OpAddrs and moves every position
+// to where its op ended up.
+func (o *optimizer) encode() {
+	newPos := make([]int, len(o.insts)+1)
+	pos := 0
+	for i, inst := range o.insts {
+		newPos[i] = pos
+		if !inst.dead {
+			pos += 1 + len(inst.ops)
+		}
+	}
+	newPos[len(o.insts)] = pos
+	at := func(old int) int {
+		return newPos[o.index[old]]
+	}
+
+	bc := o.bc
+	ops := make([]int, 0, pos)
+	for _, inst := range o.insts {
+		if inst.dead {
+			continue
+		}
+		ops = append(ops, inst.op)
+		for j, typ := range inst.args {
+			if typ == jumpPos {
+				ops = append(ops, at(inst.ops[j]))
+			} else {
+				ops = append(ops, inst.ops[j])
+			}
+		}
+	}
+	for slot := range o.posSlots {
+		if _, ok := o.index[bc.Ints[slot]]; ok {
+			bc.Ints[slot] = at(bc.Ints[slot])
+		}
+	}
+	for i := range bc.Funcs {
+		bc.Funcs[i].Entry = at(bc.Funcs[i].Entry)
+	}
+	// A line whose ops were all dropped would share its position with the
+	// next line, which is the one the op there came from.
+	var lines []LineMark
+	for _, mark := range bc.Lines {
+		mark.Pos = at(mark.Pos)
+		switch {
+		case mark.Pos == pos:
+		case len(lines) > 0 && lines[len(lines)-1].Pos == mark.Pos:
+			lines[len(lines)-1] = mark
+		default:
+			lines = append(lines, mark)
+		}
+	}
+	bc.OpAddrs = ops
+	bc.Lines = lines
+}
+
+// appendSlot adds a slot holding the scalar v to the pool of typ.
+func (bc *Bytecode) appendSlot(typ Type, v Value) int {
+	addr := bc.poolLen(typ)
+	switch typ {
+	case Int:
+		bc.Ints = append(bc.Ints, v.Int)
+	case Str:
+		bc.Strs = append(bc.Strs, v.Str)
+	case Bool:
+		bc.Bools = append(bc.Bools, v.Bool)
+	case Float:
+		bc.Floats = append(bc.Floats, v.Float)
+	}
+	return addr
+}
@@ -0,0 +1,129 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// scriptInputs gives the scripts under examples and bench that declare in
+// parameters something to run with.
+var scriptInputs = map[string]map[string]any{
+	"params.ez": {"start": 4, "step": 9},
+}
+
+func scriptPaths(t testing.TB) []string {
+	t.Helper()
+	var paths []string
+	for _, dir := range []string{"examples", "bench"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.ez"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no scripts found")
+	}
+	return paths
+}
+
+func compileFile(t testing.TB, path string) *Program {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	prog, err := Compile(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return prog
+}
+
+func TestOptimizeKeepsBehavior(t *testing.T) {
+	for _, path := range scriptPaths(t) {
+		t.Run(path, func(t *testing.T) {
+			prog, opt := compileFile(t, path), compileFile(t, path)
+			if err := opt.Optimize(); err != nil {
+				t.Fatal(err)
+			}
+			inputs := scriptInputs[filepath.Base(path)]
+			var want, got bytes.Buffer
+			wantOuts, err := prog.ExecWithOptions(context.Background(), inputs, Options{Output: &want})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotOuts, err := opt.ExecWithOptions(context.Background(), inputs, Options{Output: &got})
+			if err != nil {
+				t.Fatalf("optimized: %v", err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("optimized program printed\n%s\nwant\n%s", got.String(), want.String())
+			}
+			if !reflect.DeepEqual(gotOuts, wantOuts) {
+				t.Errorf("optimized program output %v, want %v", gotOuts, wantOuts)
+			}
+			if len(opt.Bytecode.OpAddrs) > len(prog.Bytecode.OpAddrs) {
+				t.Errorf("optimizing grew the ops from %d to %d", len(prog.Bytecode.OpAddrs), len(opt.Bytecode.OpAddrs))
+			}
+		})
+	}
+}
+
+// lineSrc divides by zero on line 2. Optimizing it folds line 5 and drops
+// the op of line 4, which moves the ops of the lines after them.
+const lineSrc = `func div a b
+  c = a / b
+  return c
+end
+x = 2 + 3
+y = x * 2
+print y
+z = x - 5
+q = div y z
+print q
+`
+
+func TestOptimizeKeepsLines(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		prog, err := Compile(strings.NewReader(lineSrc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if optimize {
+			if err := prog.Optimize(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		prof := &Profile{}
+		_, err = prog.ExecWithOptions(context.Background(), nil, Options{Output: new(bytes.Buffer), Profile: prof})
+		checkRuntimeErr(t, err, runtimeErrTest{err: ErrDivisionByZero, line: 2, detail: "'b'"})
+		var lines []uint16
+		for _, stat := range prof.Lines() {
+			lines = append(lines, stat.Line)
+		}
+		slices.Sort(lines)
+		if want := []uint16{1, 2, 5, 6, 7, 8, 9}; !slices.Equal(lines, want) {
+			t.Errorf("optimized %v: profiled lines %v, want %v", optimize, lines, want)
+		}
+
+		d, err := prog.Debug(nil, Options{Output: new(bytes.Buffer)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.SetBreakpoint(8)
+		if err := d.Continue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if d.Line() != 8 {
+			t.Errorf("optimized %v: stopped on line %d, want 8", optimize, d.Line())
+		}
+	}
+}
@@ -2,11 +2,11 @@ package ez
 
 import (
 	"bufio"
-	"errors"
 	"io"
+	"sort"
 	"strconv"
-	"strings"
-	"unicode"
+
+	"github.com/jakevn/ez/ast"
 )
 
 var (
@@ -14,10 +14,10 @@ var (
 	MaxLines   uint16 = 800
 )
 
-type baseType int
+type Type int
 
 const (
-	Und baseType = iota
+	Und Type = iota
 	Int
 	Str
 	Bool
@@ -27,20 +27,91 @@ const (
 	ArrInt
 	ArrStr
 	ArrBool
+	Float
 )
 
+func (t Type) String() string {
+	switch t {
+	case Und:
+		return "und"
+	case Int:
+		return "int"
+	case Str:
+		return "str"
+	case Bool:
+		return "bool"
+	case Any:
+		return "any"
+	case Addr:
+		return "addr"
+	case ArrUnd:
+		return "[und]"
+	case ArrInt:
+		return "[int]"
+	case ArrStr:
+		return "[str]"
+	case ArrBool:
+		return "[bool]"
+	case Float:
+		return "float"
+	}
+	return "type(" + strconv.Itoa(int(t)) + ")"
+}
+
 type Parser struct {
-	bc                  Bytecode
-	IDInfo              map[string]Info
-	UndecidedDependents map[string][]string
-	InParams            map[string]Param
-	OutParams           map[string]Param
-	undecidedAddrIndex  int
-	line                uint16
+	bc           Bytecode
+	IDInfo       map[string]Info
+	InParams     map[string]Param
+	OutParams    map[string]Param
+	funcs        map[string][]Func
+	funcIDInfo   map[int]map[string]Info // identifiers of each finished function by index
+	intDefaults  map[int]intDefault      // parameters each finished function defaulted to int, by index
+	failedFuncs  map[string]bool         // functions whose body failed to compile
+	env          *Env
+	fn           *funcScope
+	blocks       []block
+	infer        *inference
+	outLines     map[string]uint16
+	temps        int // temporaries allocated for nested expressions
+	line         uint16
+	span         [2]int // byte offsets of the token or statement being parsed
+	syntax       *ast.Parser
+	fields       []string // leaves of the statement being parsed
+	spans        [][2]int
+	jumped       string // return, goto, break or continue ending the code reached so far, if any
+	collectDiags bool
+	diags        []Diagnostic
+}
+
+// funcScope is the state of the function whose body is being parsed. The
+// enclosing identifiers are set aside so the body only sees its own.
+// outIDs holds the identifiers returned by the first 'return' whose types
+// were still undecided, "" for the others.
+type funcScope struct {
+	index       int
+	name        string
+	line        uint16
+	params      []string
+	paramSpans  [][2]int
+	outKnown    bool
+	outIDs      []string
+	skipSlot    int
+	poolStart   Frame
+	outerIDInfo map[string]Info
+	outerInfer  *inference
+	ints        intDefault
+}
+
+// intDefault lists the parameters of a function that were given int
+// because its body would take int or float for them, and the line of the
+// first op that did so.
+type intDefault struct {
+	params []string
+	line   uint16
 }
 
 type Info struct {
-	Type      baseType
+	Type      Type
 	Addresses []Address
 }
 
@@ -51,7 +122,7 @@ type Address struct {
 
 type Param struct {
 	Pos  int
-	Type baseType
+	Type Type
 	Addr int
 }
 
@@ -60,16 +131,28 @@ type expressionCtx struct {
 	args   []string
 	op     string
 	array  bool
+	stmt   bool // op starts the statement, as 'if' and 'goto' must
 }
 
 func Parse(reader io.Reader) (Bytecode, error) {
+	return newParser(nil).parseInternal(reader)
+}
+
+func newParser(env *Env) *Parser {
 	p := &Parser{
-		IDInfo:              map[string]Info{},
-		InParams:            map[string]Param{},
-		UndecidedDependents: map[string][]string{},
-		undecidedAddrIndex:  -100,
+		env:         env,
+		IDInfo:      map[string]Info{},
+		InParams:    map[string]Param{},
+		OutParams:   map[string]Param{},
+		funcs:       map[string][]Func{},
+		funcIDInfo:  map[int]map[string]Info{},
+		intDefaults: map[int]intDefault{},
+		failedFuncs: map[string]bool{},
+		infer:       newInference(),
+		outLines:    map[string]uint16{},
 	}
-	return p.parseInternal(reader)
+	p.syntax = &ast.Parser{Funcs: p.isFuncCall}
+	return p
 }
 
 func (p *Parser) parseInternal(reader io.Reader) (Bytecode, error) {
@@ -79,330 +162,708 @@ func (p *Parser) parseInternal(reader io.Reader) (Bytecode, error) {
 	for scanner.Scan() {
 		p.line += 1
 		if p.line > MaxLines {
-			return p.bc, errors.New("exceeded max number of lines: " + strconv.Itoa(int(MaxLines)))
+			p.span = [2]int{}
+			return p.bc, p.parsingErr(CodeLimit, "exceeded max number of lines: "+strconv.Itoa(int(MaxLines)))
 		}
-		lineText := scanner.Text()
-		if len(lineText) == 0 || lineText[0] == '#' {
-			continue
+		if err := p.parseLine(scanner.Text()); err != nil {
+			if err := p.report(err); err != nil {
+				return p.bc, err
+			}
 		}
-		if len(lineText) > MaxLineLen {
-			return p.bc, p.parsingErr("exceeded max line length: " + strconv.Itoa(MaxLineLen))
+	}
+	if len(p.blocks) > 0 {
+		block := p.blocks[len(p.blocks)-1]
+		p.line, p.span = block.line, [2]int{}
+		return p.bc, p.parsingErr(CodeBlock, block.kind+" block is missing its 'end'").withHint("add a line containing 'end' after the body of the " + block.kind)
+	}
+	if p.fn != nil {
+		p.line, p.span = p.fn.line, [2]int{}
+		return p.bc, p.parsingErr(CodeBlock, "function '"+p.fn.name+"' is missing its 'end'").withHint("add a line containing 'end' after the body of '" + p.fn.name + "'")
+	}
+	if err := p.inferTypes(); err != nil {
+		if err := p.report(err); err != nil {
+			return p.bc, err
 		}
+	}
+	p.bc.Names = p.slotNames()
+	return p.bc, nil
+}
 
-		var baseExprCtx expressionCtx
-		var buildStr string
-		var buildingStr bool
-		var buildingAssgns bool
-		var injectEndAddrAt int
-		fields := strings.Fields(lineText)
-		for i, field := range fields {
-			if !buildingStr && strings.HasPrefix(field, "#") {
-				break
-			}
-			if i == 0 && isLabel(field) {
-				p.newAlloc(field, Addr)
-				if len(fields) > 1 && !strings.HasPrefix(fields[1], "#") {
-					return p.bc, p.parsingErr("labels can only be followed by a comment")
-				}
-				break
+// slotNames lists the slot of every identifier and label, ordered by scope
+// then name so that the output does not depend on map iteration.
+func (p *Parser) slotNames() []SlotName {
+	var names []SlotName
+	add := func(ids map[string]Info, fn int) {
+		start := len(names)
+		for id, info := range ids {
+			addr := info.Addresses[len(info.Addresses)-1].Index
+			if addr < 0 || !ast.IsIdentifier(id) && !ast.IsLabel(id) {
+				continue
 			}
+			names = append(names, SlotName{Type: info.Type, Slot: addr, Name: id, Func: fn})
+		}
+		scope := names[start:]
+		sort.Slice(scope, func(i, j int) bool { return scope[i].Name < scope[j].Name })
+	}
+	add(p.IDInfo, -1)
+	for i := range p.bc.Funcs {
+		add(p.funcIDInfo[i], i)
+	}
+	return names
+}
 
-			if strings.HasPrefix(field, "[") {
-				if baseExprCtx.array == true {
-					return p.bc, p.parsingErr("errant '[' in array declaration")
-				}
-				baseExprCtx.array = true
-				field = field[1:]
-				if len(field) == 1 {
-					continue
-				}
-			}
-			if baseExprCtx.array && strings.HasSuffix(field, "]") {
-				field = field[:len(field)-2]
-			}
+func (p *Parser) parseLine(lineText string) error {
+	if len(lineText) == 0 || lineText[0] == '#' {
+		return nil
+	}
+	p.span = [2]int{0, len(lineText)}
+	if len(lineText) > MaxLineLen {
+		return p.parsingErr(CodeLimit, "exceeded max line length: "+strconv.Itoa(MaxLineLen))
+	}
+	stmt, err := p.syntax.ParseLine(int(p.line), lineText)
+	if err != nil {
+		return p.syntaxErr(err)
+	}
+	if _, ok := stmt.(*ast.CommentStmt); ok || stmt == nil {
+		return nil
+	}
+	p.fields, p.spans = leaves(stmt)
+	lineStart := len(p.bc.OpAddrs)
+	p.span = nodeSpan(stmt)
+	p.reach(stmt)
+	if err := p.compileStmt(stmt); err != nil {
+		return err
+	}
+	if len(p.bc.OpAddrs) > lineStart {
+		p.bc.Lines = append(p.bc.Lines, LineMark{Pos: lineStart, Line: p.line})
+	}
+	return nil
+}
 
-			switch {
-			case buildingStr:
-				buildStr += " " + field
-				if isStringEnd(field) {
-					buildingStr = false
-					baseExprCtx.args = append(baseExprCtx.args, buildStr)
-				}
-			case i == 0 && isIdentifier(field):
-				baseExprCtx.assgns = append(baseExprCtx.assgns, field)
-				buildingAssgns = true
-			case field == "=":
-				if !buildingAssgns {
-					return p.bc, p.parsingErr("expected one or more identifiers to left of assigment operator")
-				}
-				buildingAssgns = false
-			case isFuncCall(field):
-				baseExprCtx.op = field
-			case isStringStart(field):
-				if len(field) >= 2 && isStringEnd(field) {
-					baseExprCtx.args = append(baseExprCtx.args, field)
-				} else {
-					buildStr = field
-					buildingStr = true
-				}
-			case isIdentifier(field) || isBool(field) || isInt(field) || isLabel(field):
-				if buildingAssgns {
-					if !isIdentifier(field) && !isLabel(field) {
-						return p.bc, p.parsingErr("expected another identifier or an assignment symbol '=', got '" + field + "'")
-					}
-					baseExprCtx.assgns = append(baseExprCtx.assgns, field)
-				} else {
-					baseExprCtx.args = append(baseExprCtx.args, field)
-				}
-				if baseExprCtx.op == "if" {
-					if err := p.compileExpression(baseExprCtx); err != nil {
-						return p.bc, err
-					}
-					injectEndAddrAt = len(p.bc.OpAddrs)
-					p.bc.OpAddrs = append(p.bc.OpAddrs, 0)
-					baseExprCtx = expressionCtx{}
-					buildingAssgns = false
-				}
-			default:
-				return p.bc, p.parsingErr("unknown symbol: " + field)
-			}
+// compileStmt compiles the statement of a line, with p.span covering it.
+func (p *Parser) compileStmt(stmt ast.Stmt) error {
+	switch s := stmt.(type) {
+	case *ast.ParamsStmt:
+		return p.compileExpression(expressionCtx{assgns: names(s.Names)})
+	case *ast.OutStmt:
+		return p.declareOutParams(names(s.Names))
+	case *ast.AssignStmt:
+		return p.compileAssign(names(s.Lhs), s.Rhs)
+	case *ast.ExprStmt:
+		return p.compileAssign(nil, s.X)
+	case *ast.LabelStmt:
+		p.newAlloc("~"+s.Label.Name, Addr)
+	case *ast.IfStmt:
+		return p.compileIf(s, false)
+	case *ast.ElseStmt:
+		span := p.span
+		p.span = [2]int{s.Else.Col - 1, s.Else.Col + len("else") - 1}
+		if err := p.beginElse(s.If != nil); err != nil || s.If == nil {
+			return err
 		}
-		if err := p.compileExpression(baseExprCtx); err != nil {
-			return p.bc, err
+		if s.If.Then != nil {
+			p.span = span
+			return p.parsingErr(CodeBlock, "'else if' cannot be followed by a statement on the same line").withHint("put the body of the branch on the lines after its condition")
+		}
+		return p.compileIf(s.If, true)
+	case *ast.WhileStmt:
+		span, top := p.span, len(p.bc.OpAddrs)
+		cond, err := p.compileExpr(s.Cond)
+		if err != nil {
+			return err
+		}
+		p.span = span
+		return p.beginWhile(cond, top)
+	case *ast.ForStmt:
+		span := p.span
+		bounds, err := p.compileArgs([]ast.Expr{s.From, s.To})
+		if err != nil {
+			return err
+		}
+		p.span = span
+		return p.beginFor(s.Var.Name, bounds[0], bounds[1])
+	case *ast.FuncStmt:
+		return p.beginFunc(s.Name.Name, names(s.Params))
+	case *ast.ReturnStmt:
+		span := p.span
+		args, err := p.compileArgs(s.Results)
+		if err != nil {
+			return err
 		}
-		if injectEndAddrAt != 0 {
-			p.bc.OpAddrs[injectEndAddrAt] = len(p.bc.OpAddrs)
-			injectEndAddrAt = 0
+		p.span = span
+		return p.compileReturn(expressionCtx{args: args, op: "return"})
+	case *ast.BranchStmt:
+		return p.compileLoopJump(expressionCtx{op: s.Tok})
+	case *ast.EndStmt:
+		if len(p.blocks) > 0 {
+			p.endBlock()
+			return nil
 		}
+		return p.endFunc()
 	}
-	return p.bc, nil
+	return nil
+}
+
+// reach warns about the first statement after a return, goto, break or
+// continue that no label, else or end makes reachable again.
+func (p *Parser) reach(stmt ast.Stmt) {
+	switch stmt.(type) {
+	case *ast.LabelStmt, *ast.ElseStmt, *ast.EndStmt, *ast.FuncStmt:
+		p.jumped = ""
+		return
+	}
+	if p.jumped != "" {
+		p.warn(CodeUnreachable, "unreachable statement after '"+p.jumped+"'")
+		p.jumped = ""
+	}
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		p.jumped = "return"
+	case *ast.BranchStmt:
+		p.jumped = s.Tok
+	case *ast.ExprStmt:
+		if call, ok := s.X.(*ast.CallExpr); ok && call.Fun.Name == "goto" {
+			p.jumped = "goto"
+		}
+	}
+}
+
+// syntaxErr reports a syntax error of the ast parser as a diagnostic.
+func (p *Parser) syntaxErr(err error) error {
+	synErr, ok := err.(*ast.Error)
+	if !ok {
+		return err
+	}
+	p.span = [2]int{synErr.Pos.Col - 1, synErr.End.Col - 1}
+	return p.parsingErr(CodeSyntax, synErr.Msg).withHint(synErr.Hint)
+}
+
+func names(idents []*ast.Ident) []string {
+	names := make([]string, len(idents))
+	for i, id := range idents {
+		names[i] = id.Name
+	}
+	return names
 }
 
 func (p *Parser) compileExpression(ctx expressionCtx) error {
 	switch {
-	case ctx.array: // Array
-		//if len(ctx.assgns) != 1 {
-		//	return p.parsingErr("array must be assigned to a single identifier")
-		//}
-		//targetTyp, targetAddr, targetFound := p.typeAndAddrOfID(ctx.assgns[0])
-		//prevTyp := -1
-		//firstIsUnd := false
-		//for i, arg := range ctx.args {
-		//	if isIdentifier(arg) {
-		//		typ, addr, found := p.typeAndAddrOfID(arg)
-		//		if !found {
-		//			return p.parsingErr("reference to uninitialized identifier: " + arg)
-		//		}
-		//	}
-		//	p.typeAndAddrOfID(arg)
-		//	if i == 0 {
-		//		continue
-		//	}
-		//}
-
+	case ctx.array:
+		return p.compileArrayLiteral(ctx)
 	case ctx.op == "" && len(ctx.args) > 0 && len(ctx.assgns) > 0:
 		if len(ctx.args) > 1 || len(ctx.assgns) > 1 {
-			return p.parsingErr("can only assign one expression to one argument")
+			return p.parsingErr(CodeSyntax, "can only assign one expression to one argument")
+		}
+		target, arg := ctx.assgns[0], ctx.args[0]
+		if ast.IsLabel(arg) {
+			p.narrowSpan(arg)
+			return p.parsingErr(CodeTypeMismatch, "cannot assign label '"+arg+"' to '"+target+"'").withHint("labels can only be jumped to with goto")
 		}
-		targetTyp, targetAddr, targetFound := p.typeAndAddrOfID(ctx.assgns[0])
-		if isIdentifier(ctx.args[0]) {
-			typ, addr, found := p.typeAndAddrOfID(ctx.args[0])
+		_, _, targetFound := p.typeAndAddrOfID(target)
+		if ast.IsIdentifier(arg) || isTemp(arg) {
+			typ, _, found := p.typeAndAddrOfID(arg)
 			if !found {
-				return p.parsingErr("reference to uninitialized identifier: " + ctx.args[0])
+				return p.undefinedErr(arg)
 			}
+			same := true
+			var err error
 			if targetFound {
-				if targetTyp != typ {
-					if typ == Und {
-						targetAddr = p.undecidedIsDecided(ctx.args[0], targetTyp)
-					} else {
-						return p.parsingErr("cannot assign '" + ctx.args[0] + "' to '" + ctx.assgns[0] + "' - type mismatch")
-					}
-				}
+				same, err = p.sameType(arg, target)
 			} else {
-				if typ == Und {
-					p.undecidedAddDependency(ctx.args[0], ctx.assgns[0])
+				p.newAlloc(target, typ)
+				if isUndecided(typ) {
+					err = p.unify(arg, target)
 				}
-				targetAddr = p.newAlloc(ctx.assgns[0], typ)
 			}
-			p.bc.OpAddrs = append(p.bc.OpAddrs, p.copyFuncInstructionForType(typ), addr, targetAddr)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return p.parsingErr(CodeTypeMismatch, "cannot assign '"+arg+"' to '"+target+"' - type mismatch")
+			}
+			p.emitCopy(arg, target)
 		} else {
 			if targetFound {
-				if targetTyp != rawToType(ctx.args[0]) {
-					return p.parsingErr("cannot assign '" + ctx.args[0] + "' to '" + ctx.assgns[0] + "' - type mismatch")
+				same, err := p.sameAsType(target, rawToType(arg))
+				if err != nil {
+					return err
+				}
+				if !same {
+					return p.parsingErr(CodeTypeMismatch, "cannot assign '"+arg+"' to '"+target+"' - type mismatch")
 				}
-				_, addr, found := p.typeAndAddrOfID(ctx.args[0])
-				if !found {
-					addr, _ = p.newAllocInitialize(ctx.args[0], ctx.args[0])
+				if _, _, found := p.typeAndAddrOfID(arg); !found {
+					p.newAllocInitialize(arg, arg)
 				}
-				p.bc.OpAddrs = append(p.bc.OpAddrs, p.copyFuncInstructionForType(targetTyp), addr, targetAddr)
+				p.emitCopy(arg, target)
+			} else if p.fn != nil || len(p.blocks) > 0 {
+				// Function locals share slots between calls, and a block may
+				// be skipped, so these are initialised by an op rather than by
+				// the slot's starting value.
+				_, typ := p.newAllocInitialize(arg, arg)
+				p.newAlloc(target, typ)
+				p.emitCopy(arg, target)
 			} else {
-				p.newAllocInitialize(ctx.assgns[0], ctx.args[0])
+				p.newAllocInitialize(target, arg)
 			}
 		}
 	case len(ctx.assgns) > 0 && len(ctx.args) == 0 && ctx.op == "":
+		if p.fn != nil {
+			return p.parsingErr(CodeBlock, "in parameters cannot be declared inside a function")
+		}
 		if len(p.InParams) > 0 {
-			return p.parsingErr("expected assignment or expression following identifier")
+			return p.parsingErr(CodeSyntax, "expected assignment or expression following identifier")
 		}
 		for i, inParamID := range ctx.assgns {
 			if _, ok := p.InParams[inParamID]; ok {
-				return p.parsingErr("in parameter identifiers must be unique - duplicate: '" + inParamID + "'")
+				return p.parsingErr(CodeDuplicate, "in parameter identifiers must be unique - duplicate: '"+inParamID+"'")
+			}
+			if _, ok := p.IDInfo[inParamID]; ok {
+				return p.parsingErr(CodeDuplicate, "in parameter shadows existing identifier: '"+inParamID+"'")
 			}
 			p.InParams[inParamID] = Param{
-				Pos: i,
+				Pos:  i,
+				Addr: p.newAlloc(inParamID, Und),
 			}
 		}
 	case ctx.op != "":
+		if (ctx.op == "if" || ctx.op == "goto") && !ctx.stmt {
+			p.narrowSpan(ctx.op)
+			if ctx.op == "if" {
+				return p.parsingErr(CodeSyntax, "'if' can only begin an if statement, not be called").withHint("write 'if' and its condition at the start of a line")
+			}
+			return p.parsingErr(CodeSyntax, "'goto' can only begin a goto statement, not be called").withHint("write 'goto ~label' on a line of its own or after the condition of a single-line if")
+		}
 		funcs, ok := baselib[ctx.op]
 		if !ok {
-			return p.parsingErr("impossible made possible - previously existing op no longer exists: " + ctx.op)
+			funcs, ok = p.funcs[ctx.op]
+		}
+		if !ok {
+			funcs, ok = p.hostFuncs(ctx.op)
+		}
+		if !ok {
+			p.narrowSpan(ctx.op)
+			if _, _, found := p.typeAndAddrOfID(ctx.op); found {
+				return p.parsingErr(CodeSyntax, "'"+ctx.op+"' is not a function")
+			}
+			if p.failedFuncs[ctx.op] {
+				return p.parsingErr(CodeUndefined, "function '"+ctx.op+"' cannot be called, as its body failed to compile")
+			}
+			return p.parsingErr(CodeUnknownSymbol, "unknown function: "+ctx.op)
+		}
+		if p.fn != nil && p.fn.name == ctx.op {
+			if err := p.checkRecursiveCall(funcs); err != nil {
+				return err
+			}
 		}
-		var argTypes []baseType
-		var argAddrs []int
 		for _, arg := range ctx.args {
-			if isIdentifier(arg) || isLabel(arg) {
-				typ, addr, found := p.typeAndAddrOfID(arg)
-				if !found {
-					return p.parsingErr("reference to uninitialized identifier: " + arg)
+			if ast.IsIdentifier(arg) || isTemp(arg) || ast.IsLabel(arg) {
+				if _, _, found := p.typeAndAddrOfID(arg); !found {
+					return p.undefinedErr(arg)
 				}
-				argTypes = append(argTypes, typ)
-				argAddrs = append(argAddrs, addr)
 			} else {
-				addr, typ := p.newAllocInitialize(arg, arg)
-				argTypes = append(argTypes, typ)
-				argAddrs = append(argAddrs, addr)
-			}
-		}
-		var assgnTypes []baseType
-		var assgnAddrs []int
-		for _, assgn := range ctx.assgns {
-			typ, addr, found := p.typeAndAddrOfID(assgn)
-			if !found {
-				typ = Any
-				addr = -1
+				p.newAllocInitialize(arg, arg)
 			}
-			assgnTypes = append(assgnTypes, typ)
-			assgnAddrs = append(assgnAddrs, addr)
 		}
-		foundFunc := false
+		operands := append(append([]string(nil), ctx.args...), ctx.assgns...)
+		var fits []Func
 		for _, fun := range funcs {
 			if len(fun.In) != len(ctx.args) || len(fun.Out) != len(ctx.assgns) {
 				continue // TODO: overlapping func names can no longer have diff param/return len
 			}
-			inTypesMatch := true
-			for i, inType := range fun.In {
-				if inType == Und {
-					continue
-				}
-				if inType != argTypes[i] {
-					inTypesMatch = false
-					break
-				}
-			}
-			if !inTypesMatch {
-				continue
+			if p.fits(operands, fun) {
+				fits = append(fits, fun)
 			}
-			outTypesMatch := true
-			for i, outType := range fun.Out {
-				if assgnTypes[i] == Und || assgnTypes[i] == Any {
-					continue
-				}
-				if outType != assgnTypes[i] {
-					outTypesMatch = false
+		}
+		if len(fits) == 0 {
+			msg, hint := "no function signature named '"+ctx.op+"' to handle types/quantity of arguments or assignments", signaturesHint(ctx.op, funcs)
+			for _, fun := range funcs {
+				if ints, ok := p.intDefaults[fun.fnIndex]; ok && fun.addr == iopCall {
+					msg += " - " + ints.describe(ctx.op)
+					hint += " - " + paramTypesHint
 					break
 				}
 			}
-			if !outTypesMatch {
+			return p.parsingErr(CodeNoSignature, msg).withHint(hint)
+		}
+		for i, assgn := range ctx.assgns {
+			if _, _, found := p.typeAndAddrOfID(assgn); found {
 				continue
 			}
-			for i, outType := range fun.Out {
-				switch assgnTypes[i] {
-				case Any:
-					assgnAddrs[i] = p.newAlloc(ctx.assgns[i], outType)
-				case Und:
-					assgnAddrs[i] = p.undecidedIsDecided(ctx.assgns[i], outType)
+			typ := fits[0].Out[i]
+			for _, fun := range fits[1:] {
+				switch {
+				case fun.Out[i] == typ:
+				case isArray(fun.Out[i]) && isArray(typ):
+					typ = ArrUnd
+				default:
+					typ = Und
 				}
 			}
-			for i, inType := range fun.In {
-				if argTypes[i] != Und {
-					continue
-				}
-				argAddrs[i] = p.undecidedIsDecided(ctx.args[i], inType)
+			p.newAlloc(assgn, typ)
+		}
+		return p.emitOverload(ctx.op, fits, operands)
+	}
+	return nil
+}
+
+func (p *Parser) compileArrayLiteral(ctx expressionCtx) error {
+	if len(ctx.assgns) != 1 || ctx.op != "" {
+		return p.parsingErr(CodeSyntax, "array literal must be assigned to a single identifier")
+	}
+	elemTyp := Und
+	var undecided []string
+	for _, arg := range ctx.args {
+		var typ Type
+		if ast.IsIdentifier(arg) || isTemp(arg) {
+			var found bool
+			typ, _, found = p.typeAndAddrOfID(arg)
+			if !found {
+				return p.undefinedErr(arg)
 			}
-			foundFunc = true
-			p.bc.OpAddrs = append(p.bc.OpAddrs, fun.addr)
-			p.bc.OpAddrs = append(p.bc.OpAddrs, argAddrs...)
-			p.bc.OpAddrs = append(p.bc.OpAddrs, assgnAddrs...)
-			break
+		} else if ast.IsLabel(arg) {
+			return p.parsingErr(CodeTypeMismatch, "labels cannot be array elements: "+arg)
+		} else {
+			_, typ = p.newAllocInitialize(arg, arg)
+		}
+		switch {
+		case typ == Und:
+			undecided = append(undecided, arg)
+		case typ != Int && typ != Str && typ != Bool:
+			return p.parsingErr(CodeTypeMismatch, "arrays can only hold int, str or bool elements, got '"+arg+"' of type "+typ.String())
+		case elemTyp == Und:
+			elemTyp = typ
+		case elemTyp != typ:
+			return p.parsingErr(CodeTypeMismatch, "array elements must all be of the same type - "+arg+" is "+typ.String()+", expected "+elemTyp.String())
 		}
-		if !foundFunc {
-			return p.parsingErr("no function signature named '" + ctx.op + "' to handle types/quantity of arguments or assignments")
+	}
+	target := ctx.assgns[0]
+	targetTyp, _, targetFound := p.typeAndAddrOfID(target)
+	if elemTyp == Und && targetFound {
+		elemTyp = elemOf(targetTyp)
+	}
+	if elemTyp == Und && len(undecided) > 0 {
+		return p.parsingErr(CodeInference, "unable to infer element type of array assigned to '"+target+"'")
+	}
+	for _, arg := range undecided {
+		if err := p.decide(arg, elemTyp); err != nil {
+			return err
+		}
+	}
+
+	arrTyp := arrayOf(elemTyp)
+	if !targetFound {
+		p.newAlloc(target, arrTyp)
+		if arrTyp == ArrUnd && p.fn == nil && len(p.blocks) == 0 {
+			return nil // a fresh slot is already empty
+		}
+	} else {
+		same, err := p.sameAsType(target, arrTyp)
+		if err != nil {
+			return err
+		}
+		if !same && !(arrTyp == ArrUnd && isArray(targetTyp)) {
+			return p.parsingErr(CodeTypeMismatch, "cannot assign array literal to '"+target+"' - type mismatch")
+		}
+	}
+
+	p.emitClear(target)
+	if len(ctx.args) > 0 {
+		appendAddr := baselibAddr("append", arrTyp)
+		for _, arg := range ctx.args {
+			p.bc.OpAddrs = append(p.bc.OpAddrs, appendAddr)
+			p.emitOperand(target)
+			p.emitOperand(arg)
+			p.emitOperand(target)
 		}
 	}
 	return nil
 }
 
-func (p *Parser) newOrGetUndecidedAddr(id string) int {
-	undecided, ok := p.IDInfo[id]
-	if !ok {
-		p.undecidedAddrIndex -= 1
-		p.IDInfo[id] = Info{
-			Type: Und,
-			Addresses: []Address{
-				{Line: p.line, Index: p.undecidedAddrIndex},
-			},
+func (p *Parser) beginFunc(name string, params []string) error {
+	if p.fn != nil {
+		return p.parsingErr(CodeBlock, "functions cannot be nested - '"+p.fn.name+"' is missing its 'end'")
+	}
+	if len(p.blocks) > 0 {
+		return p.parsingErr(CodeBlock, "functions cannot be declared inside an if or loop block")
+	}
+	if _, ok := p.IDInfo[name]; ok {
+		return p.parsingErr(CodeDuplicate, "function name shadows existing identifier: '"+name+"'")
+	}
+	if isFuncCall(name) {
+		p.narrowSpan(name)
+		return p.parsingErr(CodeDuplicate, "function name shadows built-in function: '"+name+"'")
+	}
+	for i, param := range params {
+		for _, prev := range params[:i] {
+			if prev == param {
+				return p.parsingErr(CodeDuplicate, "parameter identifiers must be unique - duplicate: '"+param+"'")
+			}
+		}
+	}
+	for _, fun := range p.funcs[name] {
+		if len(fun.In) == len(params) {
+			return p.parsingErr(CodeDuplicate, "function '"+name+"' with "+strconv.Itoa(len(params))+" parameters is already defined")
 		}
-		return p.undecidedAddrIndex
 	}
-	return undecided.Addresses[len(undecided.Addresses)-1].Index
+
+	span := p.span
+	paramSpans := make([][2]int, len(params))
+	for i, param := range params {
+		p.narrowSpan(param)
+		paramSpans[i], p.span = p.span, span
+	}
+
+	skipSlot := len(p.bc.Ints)
+	p.bc.Ints = append(p.bc.Ints, 0)
+	p.bc.OpAddrs = append(p.bc.OpAddrs, baselibAddr("goto", Addr), skipSlot)
+	p.fn = &funcScope{
+		index:       len(p.bc.Funcs),
+		name:        name,
+		line:        p.line,
+		params:      params,
+		paramSpans:  paramSpans,
+		skipSlot:    skipSlot,
+		poolStart:   p.poolEnds(),
+		outerIDInfo: p.IDInfo,
+		outerInfer:  p.infer,
+	}
+	p.bc.Funcs = append(p.bc.Funcs, FuncInfo{Name: name, Entry: len(p.bc.OpAddrs)})
+	p.IDInfo = map[string]Info{}
+	p.infer = newInference()
+	for _, param := range params {
+		p.newAlloc(param, Und)
+	}
+	p.funcs[name] = append(p.funcs[name], Func{
+		In:      make([]Type, len(params)),
+		addr:    iopCall,
+		fnIndex: p.fn.index,
+	})
+	return nil
 }
 
-func (p *Parser) undecidedAddDependency(id, dependentId string) {
-	dependents, ok := p.UndecidedDependents[id]
-	if !ok {
-		p.UndecidedDependents[id] = []string{dependentId}
-		return
+func (p *Parser) endFunc() error {
+	if p.fn == nil {
+		return p.parsingErr(CodeBlock, "'end' without matching 'func'")
+	}
+	if err := p.inferTypes(); err != nil {
+		p.failFunc()
+		return err
+	}
+	p.syncFunc()
+	info := &p.bc.Funcs[p.fn.index]
+	info.In = make([]Type, len(p.fn.params))
+	info.Params = make([]int, len(p.fn.params))
+	for i, param := range p.fn.params {
+		typ, addr, _ := p.typeAndAddrOfID(param)
+		if typ == Und || typ == ArrUnd {
+			err := p.errAt(p.fn.line, p.fn.paramSpans[i], CodeInference, "unable to infer type of parameter '"+param+"' of '"+p.fn.name+"'").withHint(paramTypesHint)
+			p.failFunc()
+			return err
+		}
+		info.In[i] = typ
+		info.Params[i] = addr
+	}
+	if len(info.Out) == 0 {
+		p.bc.OpAddrs = append(p.bc.OpAddrs, iopReturn, p.fn.index)
+	} else {
+		p.bc.OpAddrs = append(p.bc.OpAddrs, iopMissingReturn, p.fn.index)
+	}
+	info.Frame = p.poolEnds()
+	info.Frame.Ints.Start = p.fn.poolStart.Ints.End
+	info.Frame.Strs.Start = p.fn.poolStart.Strs.End
+	info.Frame.Bools.Start = p.fn.poolStart.Bools.End
+	info.Frame.IntArrs.Start = p.fn.poolStart.IntArrs.End
+	info.Frame.StrArrs.Start = p.fn.poolStart.StrArrs.End
+	info.Frame.BoolArrs.Start = p.fn.poolStart.BoolArrs.End
+	info.Frame.Floats.Start = p.fn.poolStart.Floats.End
+	p.bc.Ints[p.fn.skipSlot] = len(p.bc.OpAddrs)
+
+	p.syncFunc()
+	p.funcIDInfo[p.fn.index] = p.IDInfo
+	if len(p.fn.ints.params) > 0 {
+		p.intDefaults[p.fn.index] = p.fn.ints
+	}
+	p.IDInfo = p.fn.outerIDInfo
+	p.infer = p.fn.outerInfer
+	p.fn = nil
+	return nil
+}
+
+// failFunc ends the function being parsed when its body cannot be
+// compiled, so that the lines after it are parsed outside of it and calls
+// of it are reported as such rather than as calls of a function with
+// unknown types.
+func (p *Parser) failFunc() {
+	funcs := p.funcs[p.fn.name]
+	for i, fun := range funcs {
+		if fun.fnIndex == p.fn.index {
+			funcs = append(funcs[:i:i], funcs[i+1:]...)
+			break
+		}
+	}
+	if len(funcs) == 0 {
+		delete(p.funcs, p.fn.name)
+		p.failedFuncs[p.fn.name] = true
+	} else {
+		p.funcs[p.fn.name] = funcs
+	}
+	p.IDInfo = p.fn.outerIDInfo
+	p.infer = p.fn.outerInfer
+	p.fn = nil
+}
+
+// syncFunc copies the currently known parameter and return types of the
+// function being parsed into its overload entry.
+func (p *Parser) syncFunc() {
+	out := p.bc.Funcs[p.fn.index].Out
+	for i, id := range p.fn.outIDs {
+		if id != "" {
+			out[i], _, _ = p.typeAndAddrOfID(id)
+		}
+	}
+	funcs := p.funcs[p.fn.name]
+	for i := range funcs {
+		if funcs[i].fnIndex != p.fn.index {
+			continue
+		}
+		for j, param := range p.fn.params {
+			funcs[i].In[j], _, _ = p.typeAndAddrOfID(param)
+		}
+		funcs[i].Out = p.bc.Funcs[p.fn.index].Out
+	}
+}
+
+func (p *Parser) checkRecursiveCall(funcs []Func) error {
+	p.syncFunc()
+	if !p.fn.outKnown {
+		return p.parsingErr(CodeInference, "return types of '"+p.fn.name+"' are unknown at this recursive call - a 'return' must come before it")
+	}
+	for _, typ := range p.bc.Funcs[p.fn.index].Out {
+		if isUndecided(typ) {
+			return p.parsingErr(CodeInference, "return types of '"+p.fn.name+"' must be known before it calls itself")
+		}
+	}
+	for _, fun := range funcs {
+		if fun.fnIndex != p.fn.index {
+			continue
+		}
+		for i, typ := range fun.In {
+			if typ == Und || typ == ArrUnd {
+				return p.parsingErr(CodeInference, "type of parameter '"+p.fn.params[i]+"' must be known before '"+p.fn.name+"' calls itself")
+			}
+		}
 	}
-	dependents = append(dependents, dependentId)
-	p.UndecidedDependents[id] = dependents
+	return nil
 }
 
-func (p *Parser) undecidedIsDecided(id string, typ baseType) int {
-	undecided, ok := p.IDInfo[id]
-	if !ok || undecided.Type != Und {
-		return -1
+func (p *Parser) compileReturn(ctx expressionCtx) error {
+	if p.fn == nil {
+		return p.parsingErr(CodeBlock, "'return' outside of function")
+	}
+	if len(ctx.assgns) > 0 {
+		return p.parsingErr(CodeSyntax, "'return' cannot be assigned")
 	}
-	undecided.Type = typ
-	p.IDInfo[id] = undecided
-	if dependents, ok := p.UndecidedDependents[id]; ok {
-		for _, dep := range dependents {
-			p.undecidedIsDecided(dep, typ)
+	types := make([]Type, len(ctx.args))
+	for i, arg := range ctx.args {
+		if ast.IsIdentifier(arg) || isTemp(arg) {
+			var found bool
+			types[i], _, found = p.typeAndAddrOfID(arg)
+			if !found {
+				return p.undefinedErr(arg)
+			}
+		} else if ast.IsLabel(arg) {
+			return p.parsingErr(CodeTypeMismatch, "labels cannot be returned: "+arg)
+		} else {
+			_, types[i] = p.newAllocInitialize(arg, arg)
 		}
 	}
-	var latestAddr int
-	for i, addr := range undecided.Addresses {
-		latestAddr = p.newAlloc(id, typ)
-		if inputParam, ok := p.InParams[id]; ok && inputParam.Type == Und {
-			inputParam.Type = typ
-			inputParam.Addr = latestAddr
-			p.InParams[id] = inputParam
+	info := &p.bc.Funcs[p.fn.index]
+	if !p.fn.outKnown {
+		info.Out = types
+		p.fn.outIDs = make([]string, len(types))
+		for i, typ := range types {
+			if isUndecided(typ) {
+				p.fn.outIDs[i] = ctx.args[i]
+			}
 		}
-		for j, opAddr := range p.bc.OpAddrs {
-			if addr.Index == opAddr {
-				p.bc.OpAddrs[j] = latestAddr
+		p.fn.outKnown = true
+		p.syncFunc()
+	} else {
+		mismatch := len(info.Out) != len(types)
+		for i := 0; !mismatch && i < len(types); i++ {
+			var same bool
+			var err error
+			if id := p.fn.outIDs[i]; id != "" {
+				same, err = p.sameType(id, ctx.args[i])
+			} else {
+				same, err = p.sameAsType(ctx.args[i], info.Out[i])
+			}
+			if err != nil {
+				return err
 			}
+			mismatch = !same
+		}
+		if mismatch {
+			return p.parsingErr(CodeTypeMismatch, "return values of '"+p.fn.name+"' do not match those of its earlier 'return'")
+		}
+	}
+	p.bc.OpAddrs = append(p.bc.OpAddrs, iopReturn, p.fn.index)
+	for _, arg := range ctx.args {
+		p.emitOperand(arg)
+	}
+	return nil
+}
+
+func (p *Parser) poolEnds() Frame {
+	return Frame{
+		Ints:     SlotRange{End: len(p.bc.Ints)},
+		Strs:     SlotRange{End: len(p.bc.Strs)},
+		Bools:    SlotRange{End: len(p.bc.Bools)},
+		IntArrs:  SlotRange{End: len(p.bc.IntArrs)},
+		StrArrs:  SlotRange{End: len(p.bc.StrArrs)},
+		BoolArrs: SlotRange{End: len(p.bc.BoolArrs)},
+		Floats:   SlotRange{End: len(p.bc.Floats)},
+	}
+}
+
+func (p *Parser) declareOutParams(ids []string) error {
+	if p.fn != nil {
+		return p.parsingErr(CodeBlock, "out parameters cannot be declared inside a function")
+	}
+	for i, field := range ids {
+		if _, ok := p.OutParams[field]; ok {
+			return p.parsingErr(CodeDuplicate, "out parameter identifiers must be unique - duplicate: '"+field+"'")
 		}
-		undecided.Addresses[i].Index = latestAddr
+		p.OutParams[field] = Param{Pos: i}
+		p.outLines[field] = p.line
 	}
-	return latestAddr
+	return nil
 }
 
-func (p *Parser) newAllocInitialize(id, raw string) (int, baseType) {
+func (p *Parser) resolveOutParams() error {
+	for id, param := range p.OutParams {
+		typ, addr, found := p.typeAndAddrOfID(id)
+		p.line, p.span = p.outLines[id], [2]int{}
+		if !found {
+			return p.parsingErr(CodeParam, "out parameter '"+id+"' is never assigned")
+		}
+		if typ == Und || typ == ArrUnd {
+			return p.parsingErr(CodeInference, "unable to infer type of out parameter '"+id+"'")
+		}
+		param.Type = typ
+		param.Addr = addr
+		p.OutParams[id] = param
+	}
+	return nil
+}
+
+func (p *Parser) newAllocInitialize(id, raw string) (int, Type) {
 	var addr int
 	typ := rawToType(raw)
 	switch typ {
 	case Str:
 		addr = len(p.bc.Strs)
-		p.bc.Strs = append(p.bc.Strs, raw[1:len(raw)-1])
+		p.bc.Strs = append(p.bc.Strs, ast.Unquote(raw))
 	case Int:
 		convInt, err := strconv.Atoi(raw)
 		if err != nil {
@@ -410,6 +871,13 @@ func (p *Parser) newAllocInitialize(id, raw string) (int, baseType) {
 		}
 		addr = len(p.bc.Ints)
 		p.bc.Ints = append(p.bc.Ints, convInt)
+	case Float:
+		convFloat, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			panic("failed to convert float '" + raw + "' even though it was parsed as a float: " + err.Error())
+		}
+		addr = len(p.bc.Floats)
+		p.bc.Floats = append(p.bc.Floats, convFloat)
 	case Bool:
 		addr = len(p.bc.Bools)
 		p.bc.Bools = append(p.bc.Bools, raw == "true")
@@ -421,8 +889,7 @@ func (p *Parser) newAllocInitialize(id, raw string) (int, baseType) {
 	return addr, typ
 }
 
-// TODO: handle copy instructions for undecided type
-func (p *Parser) copyFuncInstructionForType(typ baseType) int {
+func copyInstructionForType(typ Type) int {
 	switch typ {
 	case Int:
 		return iopIntCopy
@@ -430,11 +897,31 @@ func (p *Parser) copyFuncInstructionForType(typ baseType) int {
 		return iopStrCopy
 	case Bool:
 		return iopBoolCopy
+	case Float:
+		return iopFloatCopy
+	case ArrInt:
+		return iopIntArrCopy
+	case ArrStr:
+		return iopStrArrCopy
+	case ArrBool:
+		return iopBoolArrCopy
 	}
 	panic("type has no copy instruction: " + strconv.Itoa(int(typ)))
 }
 
-func (p *Parser) newAlloc(id string, typ baseType) int {
+func arrClearInstructionForType(typ Type) int {
+	switch typ {
+	case ArrInt:
+		return iopIntArrClear
+	case ArrStr:
+		return iopStrArrClear
+	case ArrBool:
+		return iopBoolArrClear
+	}
+	panic("type has no array clear instruction: " + strconv.Itoa(int(typ)))
+}
+
+func (p *Parser) newAlloc(id string, typ Type) int {
 	var addr int
 	switch typ {
 	case Int:
@@ -446,8 +933,21 @@ func (p *Parser) newAlloc(id string, typ baseType) int {
 	case Bool:
 		addr = len(p.bc.Bools)
 		p.bc.Bools = append(p.bc.Bools, false)
-	case Und:
-		addr = p.newOrGetUndecidedAddr(id) // TODO
+	case Float:
+		addr = len(p.bc.Floats)
+		p.bc.Floats = append(p.bc.Floats, 0)
+	case ArrInt:
+		addr = len(p.bc.IntArrs)
+		p.bc.IntArrs = append(p.bc.IntArrs, nil)
+	case ArrStr:
+		addr = len(p.bc.StrArrs)
+		p.bc.StrArrs = append(p.bc.StrArrs, nil)
+	case ArrBool:
+		addr = len(p.bc.BoolArrs)
+		p.bc.BoolArrs = append(p.bc.BoolArrs, nil)
+	case Und, ArrUnd:
+		addr = -1
+		p.infer.groups[id] = &typeGroup{ids: []string{id}, array: typ == ArrUnd}
 	case Addr:
 		addr = len(p.bc.Ints)
 		p.bc.Ints = append(p.bc.Ints, len(p.bc.OpAddrs))
@@ -459,68 +959,141 @@ func (p *Parser) newAlloc(id string, typ baseType) int {
 	return addr
 }
 
-func (p *Parser) parsingErr(errMsg string) error {
-	return errors.New("ERROR - line " + strconv.Itoa(int(p.line)) + ": " + errMsg)
+func (p *Parser) parsingErr(code, errMsg string) *Diagnostic {
+	diag := &Diagnostic{
+		Line:     p.line,
+		Severity: SeverityError,
+		Code:     code,
+		Message:  errMsg,
+	}
+	if p.span[1] > p.span[0] {
+		diag.Col, diag.EndCol = p.span[0]+1, p.span[1]+1
+	}
+	return diag
+}
+
+// leaves returns the operands and function names of stmt with their spans,
+// in source order, for narrowSpan.
+func leaves(stmt ast.Stmt) ([]string, [][2]int) {
+	var fields []string
+	var spans [][2]int
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		var field string
+		switch n := n.(type) {
+		case *ast.Ident:
+			field = n.Name
+		case *ast.BasicLit:
+			field = n.Value
+		case *ast.Label:
+			field = "~" + n.Name
+		case *ast.BadExpr:
+			field = n.Text
+		default:
+			return true
+		}
+		fields = append(fields, field)
+		spans = append(spans, nodeSpan(n))
+		return true
+	})
+	return fields, spans
+}
+
+// narrowSpan points p.span at the first field of the current statement that
+// is exactly token, if there is one.
+func (p *Parser) narrowSpan(token string) {
+	for i, field := range p.fields {
+		if field == token && p.spans[i][0] >= p.span[0] && p.spans[i][1] <= p.span[1] {
+			p.span = p.spans[i]
+			return
+		}
+	}
+}
+
+func (p *Parser) undefinedErr(id string) *Diagnostic {
+	p.narrowSpan(id)
+	diag := p.parsingErr(CodeUndefined, "reference to uninitialized identifier: "+id)
+	if ast.IsLabel(id) {
+		return diag.withHint("labels must be declared on their own line before the goto that uses them")
+	}
+	return diag.withHint("assign a value to '" + id + "' before using it")
+}
+
+func signaturesHint(name string, funcs []Func) string {
+	hint := "'" + name + "' accepts"
+	for i, fun := range funcs {
+		if i > 0 {
+			hint += ","
+		}
+		hint += " " + funcSignature(fun.In, fun.Out)
+	}
+	return hint
 }
 
-func (p *Parser) typeAndAddrOfID(id string) (baseType, int, bool) {
+func funcSignature(in, out []Type) string {
+	sig := "("
+	for i, typ := range in {
+		if i > 0 {
+			sig += " "
+		}
+		sig += typ.String()
+	}
+	sig += ")"
+	if len(out) > 0 {
+		sig += " ->"
+		for _, typ := range out {
+			sig += " " + typ.String()
+		}
+	}
+	return sig
+}
+
+func (p *Parser) typeAndAddrOfID(id string) (Type, int, bool) {
 	if info, ok := p.IDInfo[id]; ok {
 		return info.Type, info.Addresses[len(info.Addresses)-1].Index, ok
 	}
 	return Und, -1, false
 }
 
-func rawToType(raw string) baseType {
+func rawToType(raw string) Type {
 	switch {
 	case isString(raw):
 		return Str
-	case isInt(raw):
+	case ast.IsInt(raw):
 		return Int
+	case ast.IsFloat(raw):
+		return Float
 	case isBool(raw):
 		return Bool
 	}
 	panic("no type for: " + raw)
 }
 
-func isStringStart(str string) bool {
-	for i, r := range str {
-		if i == 0 {
-			if r != '\'' {
-				return false
-			}
-		}
-		return true
-	}
-	return false
-}
-
-func isStringEnd(str string) bool {
-	return len(str) > 0 && str[len(str)-1] == '\'' && (len(str) == 1 || str[len(str)-2] != '\\')
-}
-
-func isInt(str string) bool {
-	_, err := strconv.Atoi(str)
-	return err == nil
+func isArray(typ Type) bool {
+	return typ >= ArrUnd && typ <= ArrBool
 }
 
-func isIdentifier(str string) bool {
-	if isFuncCall(str) {
-		return false
-	}
-	for i, r := range str {
-		if i == 0 {
-			if !unicode.IsLetter(r) || !unicode.IsLower(r) {
-				return false
-			}
-		} else if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
-			return false
-		}
+func arrayOf(typ Type) Type {
+	switch typ {
+	case Int:
+		return ArrInt
+	case Str:
+		return ArrStr
+	case Bool:
+		return ArrBool
 	}
-	return true
+	return ArrUnd
 }
 
-func isLabel(str string) bool {
-	return str[0] == '~' && len(str) > 1 && isIdentifier(str[1:])
+func elemOf(typ Type) Type {
+	switch typ {
+	case ArrInt:
+		return Int
+	case ArrStr:
+		return Str
+	case ArrBool:
+		return Bool
+	}
+	return Und
 }
 
 func isString(raw string) bool {
@@ -535,3 +1108,18 @@ func isFuncCall(str string) bool {
 	_, ok := baselib[str]
 	return ok
 }
+
+func (p *Parser) isFuncCall(str string) bool {
+	if _, ok := p.funcs[str]; ok {
+		return true
+	}
+	return p.env.IsFunc(str)
+}
+
+func (p *Parser) hostFuncs(name string) ([]Func, bool) {
+	if p.env == nil {
+		return nil, false
+	}
+	funcs, ok := p.env.funcs[name]
+	return funcs, ok
+}
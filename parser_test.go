@@ -0,0 +1,112 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxDiagnostics(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		line      uint16
+		col       int
+		code, msg string
+	}{
+		{
+			name: "if with an infix condition and an assignment",
+			src:  "n = 1\nif n == 0 x = 1\n",
+			line: 2, col: 11, code: CodeSyntax,
+			msg: "only a call, 'return', 'break' or 'continue' can follow the condition",
+		},
+		{
+			name: "goto a missing label",
+			src:  "goto ~fwd\n",
+			line: 1, col: 6, code: CodeUndefined,
+			msg: "~fwd",
+		},
+		{
+			name: "unknown function",
+			src:  "x = foo 1\n",
+			line: 1, col: 5, code: CodeUnknownSymbol,
+			msg: "unknown function: foo",
+		},
+		{
+			name: "identifier called",
+			src:  "x = 1\ny = x 2\n",
+			line: 2, col: 5, code: CodeSyntax,
+			msg: "'x' is not a function",
+		},
+		{
+			name: "while without a condition",
+			src:  "while\n",
+			line: 1, col: 1, code: CodeSyntax,
+			msg: "expected a bool expression after 'while'",
+		},
+		{
+			name: "if called",
+			src:  "b = true\nb if\n",
+			line: 2, col: 3, code: CodeSyntax,
+			msg: "'if' can only begin an if statement",
+		},
+		{
+			name: "if assigned",
+			src:  "b = true\nx = if b\n",
+			line: 2, col: 5, code: CodeSyntax,
+			msg: "'if' can only begin an if statement",
+		},
+		{
+			name: "goto in an expression",
+			src:  "~top\nok = true\ny = ok && (goto ~top)\n",
+			line: 3, col: 12, code: CodeSyntax,
+			msg: "'goto' can only begin a goto statement",
+		},
+		{
+			name: "label assigned",
+			src:  "~top\ny = ~top\n",
+			line: 2, col: 5, code: CodeTypeMismatch,
+			msg: "cannot assign label '~top' to 'y'",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, diags := ParseDiagnostics(strings.NewReader(test.src))
+			if len(diags) == 0 {
+				t.Fatal("no diagnostics")
+			}
+			d := diags[0]
+			if d.Line != test.line || d.Col != test.col || d.Code != test.code || !strings.Contains(d.Message, test.msg) {
+				t.Errorf("got %s %d:%d %q, want %s %d:%d containing %q", d.Code, d.Line, d.Col, d.Message, test.code, test.line, test.col, test.msg)
+			}
+		})
+	}
+}
+
+func TestSingleLineIf(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"operand and call", "ok = true\nif ok print 1\n", "1\n"},
+		{"infix and call", "a = 1\nif a < 2 print a\nif a > 2 print 0\n", "1\n"},
+		{"infix and return", "func f n\n  if n == 0 return 9\n  return n\nend\nx = f 0\nprint x\n", "9\n"},
+		{"chain and break", "i = 0\nwhile true\n  i = i + 1\n  if i * 2 >= 6 && true break\nend\nprint i\n", "3\n"},
+		{"parenthesised and continue", "for i from 1 to 3\n  if (i == 2) continue\n  print i\nend\n", "1\n3\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, err := Parse(strings.NewReader(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := RunWithOptions(context.Background(), &bc, Options{Output: &out}); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != test.want {
+				t.Errorf("printed %q, want %q", got, test.want)
+			}
+		})
+	}
+}
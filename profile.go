@@ -0,0 +1,151 @@
+package ez
+
+import (
+	"sort"
+	"time"
+)
+
+// Profile accumulates how many times each op of a run executes and how long
+// it takes. Set Options.Profile to profile a run. Runs of the same bytecode
+// add to the counts and times in a Profile, while a run of other bytecode,
+// such as an optimized copy, starts it over. Timing every op slows the run
+// down, and the times include part of that overhead.
+type Profile struct {
+	ops     []int
+	lines   []LineMark
+	counts  []int
+	times   []time.Duration
+	pending int // position of the op being timed, -1 if none
+	started time.Time
+}
+
+// OpStat is the share of a profile taken by one opcode, such as the int
+// overload of "+".
+type OpStat struct {
+	Op    string
+	Count int
+	Time  time.Duration
+}
+
+// LineStat is the share of a profile taken by the ops of one source line,
+// 0 for bytecode without line marks.
+type LineStat struct {
+	Line  uint16
+	Count int
+	Time  time.Duration
+}
+
+func (prof *Profile) begin(bc *Bytecode) {
+	if !sameOps(prof.ops, bc.OpAddrs) {
+		prof.counts = make([]int, len(bc.OpAddrs))
+		prof.times = make([]time.Duration, len(bc.OpAddrs))
+	}
+	prof.ops, prof.lines = bc.OpAddrs, bc.Lines
+	prof.pending = -1
+}
+
+// sameOps reports whether a and b are the same ops, not just equal ones.
+func sameOps(a, b []int) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// enter stops timing the op before and starts timing the op at pos.
+func (prof *Profile) enter(pos int) {
+	now := time.Now()
+	if prof.pending >= 0 {
+		prof.times[prof.pending] += now.Sub(prof.started)
+	}
+	prof.counts[pos]++
+	prof.pending, prof.started = pos, now
+}
+
+func (prof *Profile) end() {
+	if prof.pending >= 0 {
+		prof.times[prof.pending] += time.Since(prof.started)
+		prof.pending = -1
+	}
+}
+
+// Total returns the number of ops executed and the time spent in them.
+func (prof *Profile) Total() (int, time.Duration) {
+	var count int
+	var total time.Duration
+	for pos, n := range prof.counts {
+		count += n
+		total += prof.times[pos]
+	}
+	return count, total
+}
+
+// Ops returns the executions and time of every opcode run, slowest first.
+func (prof *Profile) Ops() []OpStat {
+	index := map[int]int{}
+	var stats []OpStat
+	for pos, n := range prof.counts {
+		if n == 0 {
+			continue
+		}
+		op := prof.ops[pos]
+		i, ok := index[op]
+		if !ok {
+			i = len(stats)
+			index[op] = i
+			stats = append(stats, OpStat{Op: opLabel(op)})
+		}
+		stats[i].Count += n
+		stats[i].Time += prof.times[pos]
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Time != stats[j].Time {
+			return stats[i].Time > stats[j].Time
+		}
+		return stats[i].Op < stats[j].Op
+	})
+	return stats
+}
+
+// Lines returns the executions and time of the ops of every source line
+// run, slowest first.
+func (prof *Profile) Lines() []LineStat {
+	marks := Bytecode{Lines: prof.lines}
+	index := map[uint16]int{}
+	var stats []LineStat
+	for pos, n := range prof.counts {
+		if n == 0 {
+			continue
+		}
+		line := marks.lineAt(pos)
+		i, ok := index[line]
+		if !ok {
+			i = len(stats)
+			index[line] = i
+			stats = append(stats, LineStat{Line: line})
+		}
+		stats[i].Count += n
+		stats[i].Time += prof.times[pos]
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Time != stats[j].Time {
+			return stats[i].Time > stats[j].Time
+		}
+		return stats[i].Line < stats[j].Line
+	})
+	return stats
+}
+
+// opLabel names an opcode by its name and operand types, which tells the
+// overloads of a function apart.
+func opLabel(op int) string {
+	code := opcodes[op]
+	var in []Type
+	for _, typ := range code.args[:len(code.args)-code.outs] {
+		switch typ {
+		case funcRef, hostRef:
+			return code.name
+		case jumpPos:
+		default:
+			in = append(in, typ)
+		}
+	}
+	return code.name + " " + funcSignature(in, code.args[len(code.args)-code.outs:])
+}
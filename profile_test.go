@@ -0,0 +1,58 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"strings"
+	"testing"
+)
+
+const loopSrc = `i = 0
+n = 0
+while i < 3
+  i = i + 1
+  n = n + i
+end
+print n
+`
+
+func TestProfileLines(t *testing.T) {
+	parse := func() Bytecode {
+		bc, err := Parse(strings.NewReader(loopSrc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bc
+	}
+	// The while line runs its test and branch once more than the loop body.
+	once := map[uint16]int{3: 8, 4: 3, 5: 3, 6: 3, 7: 1}
+	twice := map[uint16]int{}
+	for line, n := range once {
+		twice[line] = 2 * n
+	}
+	bc, other := parse(), parse()
+	prof := &Profile{}
+	runs := []struct {
+		name string
+		bc   *Bytecode
+		want map[uint16]int
+	}{
+		{"first run", &bc, once},
+		{"same bytecode again", &bc, twice},
+		{"other bytecode", &other, once},
+	}
+	for _, run := range runs {
+		err := RunWithOptions(context.Background(), run.bc, Options{Output: new(bytes.Buffer), Profile: prof})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := map[uint16]int{}
+		for _, stat := range prof.Lines() {
+			counts[stat.Line] = stat.Count
+		}
+		if !maps.Equal(counts, run.want) {
+			t.Errorf("%s: line counts %v, want %v", run.name, counts, run.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package ez
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Program is a compiled script. Its Bytecode must not be changed other than
+// by Program.Optimize, since what every run needs to know about it is worked
+// out once, when it is compiled or optimized.
+type Program struct {
+	Bytecode  Bytecode
+	InParams  map[string]Param
+	OutParams map[string]Param
+	env       *Env
+
+	// Set by prepare for the machines the program runs on.
+	opStarts []bool
+	written  poolSet
+	hosts    []HostFunc // bound against env
+}
+
+func Compile(reader io.Reader) (*Program, error) {
+	return compile(newParser(nil), reader)
+}
+
+func compile(p *Parser, reader io.Reader) (*Program, error) {
+	bc, err := p.parseInternal(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.resolveOutParams(); err != nil {
+		return nil, err
+	}
+	prog := &Program{
+		Bytecode:  bc,
+		InParams:  p.InParams,
+		OutParams: p.OutParams,
+		env:       p.env,
+	}
+	prog.prepare()
+	return prog, nil
+}
+
+// prepare verifies the program's bytecode and binds its host functions once
+// for all its machines. Bytecode failing either is left for each run to
+// report.
+func (prog *Program) prepare() {
+	prog.opStarts, prog.hosts = nil, nil
+	opStarts, err := verify(&prog.Bytecode)
+	if err != nil {
+		return
+	}
+	prog.opStarts, prog.written = opStarts, prog.Bytecode.writtenPools()
+	if hosts, err := prog.Bytecode.bindHosts(prog.env); err == nil {
+		prog.hosts = hosts
+	}
+}
+
+// Exec runs the program on a new Machine, so a Program can be executed any
+// number of times, including from several goroutines at once.
+func (prog *Program) Exec(inputs map[string]any) (map[string]any, error) {
+	return prog.ExecWithOptions(context.Background(), inputs, Options{})
+}
+
+func (prog *Program) ExecWithOptions(ctx context.Context, inputs map[string]any, opts Options) (map[string]any, error) {
+	m := prog.NewMachine()
+	if err := m.SetInputs(inputs); err != nil {
+		return nil, err
+	}
+	if err := m.Run(ctx, opts); err != nil {
+		return nil, err
+	}
+	if err := m.finished(); err != nil {
+		return nil, err
+	}
+	return m.Outputs(), nil
+}
+
+func (m *Machine) setParam(id string, param Param, val any) error {
+	m.own(param.Type)
+	switch param.Type {
+	case Und:
+		return nil // never referenced, so there is no slot to fill
+	case Int:
+		if i, ok := toInt(val); ok {
+			m.Ints[param.Addr] = i
+			return nil
+		}
+	case Str:
+		if s, ok := val.(string); ok {
+			m.Strs[param.Addr] = s
+			return nil
+		}
+	case Bool:
+		if b, ok := val.(bool); ok {
+			m.Bools[param.Addr] = b
+			return nil
+		}
+	case Float:
+		if f, ok := toFloat(val); ok {
+			m.Floats[param.Addr] = f
+			return nil
+		}
+	case ArrInt:
+		if arr, ok := val.([]int); ok {
+			m.IntArrs[param.Addr] = append([]int(nil), arr...)
+			return nil
+		}
+	case ArrStr:
+		if arr, ok := val.([]string); ok {
+			m.StrArrs[param.Addr] = append([]string(nil), arr...)
+			return nil
+		}
+	case ArrBool:
+		if arr, ok := val.([]bool); ok {
+			m.BoolArrs[param.Addr] = append([]bool(nil), arr...)
+			return nil
+		}
+	}
+	return errors.New("in parameter '" + id + "' expects " + param.Type.String() + ", got incompatible value")
+}
+
+// toFloat also accepts any integer toInt accepts.
+func toFloat(val any) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	i, ok := toInt(val)
+	return float64(i), ok
+}
+
+func toInt(val any) (int, bool) {
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	}
+	return 0, false
+}
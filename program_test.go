@@ -0,0 +1,113 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMachineRebindsHosts(t *testing.T) {
+	prog := compileBinarySrc(t)
+	other := NewEnv()
+	err := other.RegisterFunc("shout", []Type{Str}, []Type{Str}, func(args []Value) ([]Value, error) {
+		return []Value{StrValue(args[0].Str + "!")}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := prog.NewMachine()
+	runs := []struct {
+		env  *Env
+		want string
+	}{
+		{nil, "HI\n"},
+		{other, "hi!\n"},
+		{NewEnv(), ""},
+		{prog.env, "HI\n"},
+		{nil, "HI\n"},
+	}
+	for i, run := range runs {
+		var out bytes.Buffer
+		m.Reset()
+		if err := m.SetInputs(map[string]any{"n": 0}); err != nil {
+			t.Fatal(err)
+		}
+		err := m.Run(context.Background(), Options{Output: &out, Env: run.env})
+		if run.want == "" {
+			if !errors.Is(err, ErrUnboundHostFunc) {
+				t.Errorf("run %d: error %v, want ErrUnboundHostFunc", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if got := out.String(); got != run.want+"1\n" {
+			t.Errorf("run %d printed %q, want %q", i, got, run.want+"1\n")
+		}
+	}
+}
+
+const inputsSrc = `n s b f nums
+out r
+t = s + 'x'
+c = b && true
+g = f * 2.0
+more = append nums 0
+r = n + (len nums)
+`
+
+func TestExecInputs(t *testing.T) {
+	prog, err := Compile(strings.NewReader(inputsSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func() map[string]any {
+		return map[string]any{"n": 1, "s": "a", "b": true, "f": 1.5, "nums": []int{1, 2}}
+	}
+	tests := []struct {
+		name string
+		id   string
+		val  any // replaces the valid input id, or with nil removes it
+		want any // r, or the error message
+	}{
+		{"valid", "n", 1, 3},
+		{"int64 for int", "n", int64(5), 7},
+		{"uint8 for int", "n", uint8(5), 7},
+		{"int for float", "f", 2, 3},
+		{"float32 for float", "f", float32(0.5), 3},
+		{"float for int", "n", 1.5, "in parameter 'n' expects int, got incompatible value"},
+		{"uint64 for int", "n", uint64(1), "in parameter 'n' expects int, got incompatible value"},
+		{"int for str", "s", 1, "in parameter 's' expects str, got incompatible value"},
+		{"str for bool", "b", "true", "in parameter 'b' expects bool, got incompatible value"},
+		{"str for float", "f", "1.5", "in parameter 'f' expects float, got incompatible value"},
+		{"strs for ints", "nums", []string{"1"}, "in parameter 'nums' expects [int], got incompatible value"},
+		{"missing", "s", nil, "missing in parameter: 's'"},
+		{"unknown", "m", 1, "unknown in parameter: 'm'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := valid()
+			if test.val == nil {
+				delete(inputs, test.id)
+			} else {
+				inputs[test.id] = test.val
+			}
+			outs, err := prog.ExecWithOptions(context.Background(), inputs, Options{Output: new(bytes.Buffer)})
+			if msg, ok := test.want.(string); ok {
+				if err == nil || err.Error() != msg {
+					t.Errorf("error %v, want %q", err, msg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outs["r"] != test.want {
+				t.Errorf("r = %v, want %v", outs["r"], test.want)
+			}
+		})
+	}
+}
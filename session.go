@@ -0,0 +1,175 @@
+package ez
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/jakevn/ez/ast"
+)
+
+// Session compiles and runs a program one line at a time, keeping the parser
+// state and the values of all variables between lines. Lines inside a
+// function body or if block are only compiled; they run once its 'end' is
+// reached.
+type Session struct {
+	p    *Parser
+	m    *Machine
+	open codeMark
+	opts Options
+}
+
+// codeMark is a position in the code being compiled: a count of ops, Ints
+// slots and functions.
+type codeMark struct {
+	ops, ints, funcs int
+}
+
+type Var struct {
+	Name  string
+	Type  Type
+	Value any
+}
+
+func NewSession(opts Options) *Session {
+	p := newParser(opts.Env)
+	return &Session{p: p, m: NewMachine(&p.bc), opts: opts}
+}
+
+// Eval compiles line and runs the ops it appended. A line that fails to
+// compile leaves the session as it was before it.
+func (s *Session) Eval(ctx context.Context, line string) error {
+	p := s.p
+	if !s.Pending() {
+		s.open = codeMark{len(p.bc.OpAddrs), len(p.bc.Ints), len(p.bc.Funcs)}
+	}
+	saved := s.checkpoint()
+	p.line++
+	err := p.parseLine(line)
+	if err == nil && len(p.InParams) > 0 {
+		err = p.parsingErr(CodeBlock, "in parameters cannot be declared in a session")
+	}
+	if err == nil && !s.Pending() {
+		err = p.inferTypes()
+	}
+	if err != nil {
+		s.restore(saved)
+		return err
+	}
+	s.m.attach(&p.bc)
+	if s.Pending() {
+		return nil
+	}
+	if err := s.m.Run(ctx, s.opts); err != nil {
+		s.m.pos = len(s.m.OpAddrs)
+		s.m.stack = nil
+		return err
+	}
+	return nil
+}
+
+// Pending reports whether a function body or if block is open, in which case
+// further lines are compiled but not run until its 'end'.
+func (s *Session) Pending() bool {
+	return s.p.fn != nil || len(s.p.blocks) > 0
+}
+
+// Reset forgets every line and variable.
+func (s *Session) Reset() {
+	s.p = newParser(s.opts.Env)
+	s.m = NewMachine(&s.p.bc)
+	s.open = codeMark{}
+}
+
+// Vars returns the variables at the top level of the session with their
+// current values, sorted by name.
+func (s *Session) Vars() []Var {
+	ids := s.p.IDInfo
+	if s.p.fn != nil {
+		ids = s.p.fn.outerIDInfo
+	}
+	return varsOf(&s.p.bc, ids)
+}
+
+// varsOf reads the current values of the variables in ids, sorted by name.
+func varsOf(bc *Bytecode, ids map[string]Info) []Var {
+	vars := make([]Var, 0, len(ids))
+	for id, info := range ids {
+		addr := info.Addresses[len(info.Addresses)-1].Index
+		if addr < 0 || !ast.IsIdentifier(id) {
+			continue
+		}
+		if !isValueType(info.Type) {
+			continue
+		}
+		vars = append(vars, Var{Name: id, Type: info.Type, Value: bc.valueAt(info.Type, addr).Any()})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// checkpoint is the state of a session's parser before a line, for Eval to
+// go back to should the line fail to compile. The bytecode is kept as the
+// lengths of its slices, which lines only append to, but for the ops, Ints
+// slots and functions from s.open on: those of a function or blocks still
+// open, which later lines patch.
+type checkpoint struct {
+	parser Parser
+	syntax ast.Parser
+	ops    []int
+	ints   []int
+	funcs  []FuncInfo
+}
+
+// checkpoint saves the state of the parser, copying whatever the next line
+// could change in place.
+func (s *Session) checkpoint() *checkpoint {
+	p := s.p
+	c := &checkpoint{parser: *p, syntax: *p.syntax}
+	saved := &c.parser
+	saved.IDInfo = maps.Clone(p.IDInfo)
+	saved.InParams = maps.Clone(p.InParams)
+	saved.OutParams = maps.Clone(p.OutParams)
+	saved.outLines = maps.Clone(p.outLines)
+	saved.funcIDInfo = maps.Clone(p.funcIDInfo)
+	saved.intDefaults = maps.Clone(p.intDefaults)
+	saved.failedFuncs = maps.Clone(p.failedFuncs)
+	saved.infer = p.infer.clone()
+	saved.blocks = slices.Clone(p.blocks)
+	if p.fn != nil {
+		fn := *p.fn
+		saved.fn = &fn
+	}
+	c.ops = slices.Clone(p.bc.OpAddrs[s.open.ops:])
+	c.ints = slices.Clone(p.bc.Ints[s.open.ints:])
+	c.funcs = slices.Clone(p.bc.Funcs[s.open.funcs:])
+	for i := range c.funcs {
+		c.funcs[i].Out = slices.Clone(c.funcs[i].Out)
+	}
+	saved.funcs = make(map[string][]Func, len(p.funcs))
+	for name, funcs := range p.funcs {
+		funcs = slices.Clone(funcs)
+		for i, fun := range funcs {
+			if fun.fnIndex >= s.open.funcs {
+				funcs[i].In = slices.Clone(fun.In)
+				funcs[i].Out = c.funcs[fun.fnIndex-s.open.funcs].Out
+			}
+		}
+		saved.funcs[name] = funcs
+	}
+	return c
+}
+
+// restore returns the parser to the state saved in c, keeping the line
+// count and the values the machine has given the slots since.
+func (s *Session) restore(c *checkpoint) {
+	line := s.p.line
+	*s.p = c.parser
+	*s.p.syntax = c.syntax
+	s.p.line = line
+	copy(s.p.bc.OpAddrs[s.open.ops:], c.ops)
+	copy(s.p.bc.Ints[s.open.ints:], c.ints)
+	copy(s.p.bc.Funcs[s.open.funcs:], c.funcs)
+	s.m.attach(&s.p.bc)
+}
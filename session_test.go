@@ -0,0 +1,46 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestSessionRollback(t *testing.T) {
+	var out bytes.Buffer
+	s := NewSession(Options{Output: &out})
+	lines := []struct {
+		text string
+		ok   bool
+	}{
+		{"x = 1", true},
+		{"x = x + 1", true},
+		{"y = x + 'a'", false},
+		{"func f a b", true},
+		{"  c = a * b", true},
+		{"  d = a + 'x'", false}, // would decide a, and so the '*' above, as str
+		{"  return c", true},
+		{"end", true},
+		{"if x > 1", true},
+		{"  x = 'str'", false},
+		{"  x = f x 1.5", false},
+		{"  x = f x 3", true},
+		{"end", true},
+		{"print x", true},
+		{"z = f 2.5 2.0", false},
+		{"print (f 2 5)", true},
+	}
+	for _, line := range lines {
+		err := s.Eval(context.Background(), line.text)
+		if (err == nil) != line.ok {
+			t.Fatalf("%q: error %v, want ok: %v", line.text, err, line.ok)
+		}
+	}
+	if got := out.String(); got != "6\n10\n" {
+		t.Errorf("printed %q", got)
+	}
+	vars := s.Vars()
+	if len(vars) != 1 || vars[0].Name != "x" || vars[0].Value != 6 {
+		t.Errorf("vars %+v, want only x = 6", vars)
+	}
+}
@@ -0,0 +1,214 @@
+package ez
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// A snapshot is the magic, the snapshot format version as a uvarint, the
+// ABI hash and the layout hash of the bytecode as 8 little-endian bytes each,
+// then sections laid out as in .ezc files: the state, the slot pools under
+// their .ezc tags and the call stack. Every section is required.
+const (
+	snapshotMagic   = "EZS\x00"
+	snapshotVersion = 1
+)
+
+// Tags of the snapshot sections other than the pools, clear of the .ezc ones.
+const (
+	sectionState byte = iota + 64
+	sectionStack
+)
+
+var (
+	ErrIncompatibleSnapshot = errors.New("incompatible snapshot")
+	errSnapshotTruncated    = errors.New("snapshot is truncated or corrupt")
+)
+
+// Snapshot encodes the state of m between runs: the position of its next op,
+// its status, the value of every slot and its call stack. Restoring it into a
+// machine of the same bytecode, in this process or another, carries on from
+// there.
+func (m *Machine) Snapshot() ([]byte, error) {
+	buf := []byte(snapshotMagic)
+	buf = binary.AppendUvarint(buf, snapshotVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, abiHash)
+	buf = binary.LittleEndian.AppendUint64(buf, m.layoutHash())
+
+	var e encoder
+	e.uint(m.pos)
+	e.uint(int(m.status))
+	buf = appendSection(buf, sectionState, e.flush())
+	buf = appendPools(buf, &m.Bytecode)
+	e.uint(len(m.stack))
+	for _, fr := range m.stack {
+		e.uint(fr.fn)
+		e.uint(fr.callPos)
+		e.ints(fr.ints)
+		e.strs(fr.strs)
+		e.bools(fr.bools)
+		e.uint(len(fr.intArrs))
+		for _, arr := range fr.intArrs {
+			e.ints(arr)
+		}
+		e.uint(len(fr.strArrs))
+		for _, arr := range fr.strArrs {
+			e.strs(arr)
+		}
+		e.uint(len(fr.boolArrs))
+		for _, arr := range fr.boolArrs {
+			e.bools(arr)
+		}
+		e.floats(fr.floats)
+	}
+	buf = appendSection(buf, sectionStack, e.flush())
+	return buf, nil
+}
+
+// Restore replaces the state of m with one taken by Snapshot from a machine
+// of the same bytecode. m is left as it was if data does not fit it.
+func (m *Machine) Restore(data []byte) error {
+	if len(data) < len(snapshotMagic) || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return errors.New("not an ez snapshot: bad magic number")
+	}
+	d := decoder{buf: data[len(snapshotMagic):]}
+	if version := d.uint(); d.err == nil && version != snapshotVersion {
+		return fmt.Errorf("%w: snapshot format version %d is not supported", ErrIncompatibleSnapshot, version)
+	}
+	if d.err != nil || len(d.buf) < 16 {
+		return errSnapshotTruncated
+	}
+	if hash := binary.LittleEndian.Uint64(d.buf); hash != abiHash {
+		return fmt.Errorf("%w: snapshot was taken against a different opcode table", ErrIncompatibleSnapshot)
+	}
+	if hash := binary.LittleEndian.Uint64(d.buf[8:]); hash != m.layoutHash() {
+		return fmt.Errorf("%w: snapshot was taken of different bytecode", ErrIncompatibleSnapshot)
+	}
+	d.buf = d.buf[16:]
+
+	var pools Bytecode
+	var pos, status int
+	var stack []callFrame
+	seen := map[byte]bool{}
+	for len(d.buf) > 0 {
+		tag := d.buf[0]
+		d.buf = d.buf[1:]
+		size := d.uint()
+		if d.err != nil || size > len(d.buf) {
+			return errSnapshotTruncated
+		}
+		s := decoder{buf: d.buf[:size]}
+		d.buf = d.buf[size:]
+		switch tag {
+		case sectionState:
+			pos = s.uint()
+			status = s.uint()
+		case sectionStack:
+			stack = make([]callFrame, s.count())
+			for i := range stack {
+				fr := &stack[i]
+				fr.fn = s.uint()
+				fr.callPos = s.uint()
+				fr.ints = s.ints()
+				fr.strs = s.strs()
+				fr.bools = s.bools()
+				fr.intArrs = make([][]int, s.count())
+				for j := range fr.intArrs {
+					fr.intArrs[j] = s.ints()
+				}
+				fr.strArrs = make([][]string, s.count())
+				for j := range fr.strArrs {
+					fr.strArrs[j] = s.strs()
+				}
+				fr.boolArrs = make([][]bool, s.count())
+				for j := range fr.boolArrs {
+					fr.boolArrs[j] = s.bools()
+				}
+				fr.floats = s.floats()
+			}
+		default:
+			if !decodePool(tag, &s, &pools) {
+				return fmt.Errorf("%w: unknown snapshot section %d", ErrIncompatibleSnapshot, tag)
+			}
+		}
+		if s.err != nil {
+			return errSnapshotTruncated
+		}
+		seen[tag] = true
+	}
+	for _, tag := range []byte{sectionState, sectionInts, sectionStrs, sectionBools, sectionIntArrs, sectionStrArrs, sectionBoolArrs, sectionFloats, sectionStack} {
+		if !seen[tag] {
+			return fmt.Errorf("%w: snapshot section %d is missing", ErrIncompatibleSnapshot, tag)
+		}
+	}
+	if err := m.checkSnapshot(&pools, pos, Status(status), stack); err != nil {
+		return err
+	}
+
+	for _, typ := range []Type{Int, Str, Bool, Float} {
+		m.owned[typ] = true
+	}
+	m.Ints, m.Strs, m.Bools, m.Floats = pools.Ints, pools.Strs, pools.Bools, pools.Floats
+	m.IntArrs, m.StrArrs, m.BoolArrs = pools.IntArrs, pools.StrArrs, pools.BoolArrs
+	m.pos, m.status, m.stack = pos, Status(status), stack
+	return nil
+}
+
+// checkSnapshot checks that decoded snapshot state fits the bytecode of m,
+// so that running it cannot index out of the pools or return to a bad op.
+func (m *Machine) checkSnapshot(pools *Bytecode, pos int, status Status, stack []callFrame) error {
+	incompatible := func(detail string) error {
+		return fmt.Errorf("%w: %s", ErrIncompatibleSnapshot, detail)
+	}
+	for _, typ := range []Type{Int, Str, Bool, Float, ArrInt, ArrStr, ArrBool} {
+		if pools.poolLen(typ) != m.poolLen(typ) {
+			return incompatible(typ.String() + " pool holds " + strconv.Itoa(pools.poolLen(typ)) + " slots, expected " + strconv.Itoa(m.poolLen(typ)))
+		}
+	}
+	if m.opStarts == nil {
+		opStarts, err := verify(&m.Bytecode)
+		if err != nil {
+			return err
+		}
+		m.opStarts = opStarts
+	}
+	if pos > len(m.OpAddrs) || !m.opStarts[pos] {
+		return incompatible("position " + strconv.Itoa(pos) + " is not the start of an op")
+	}
+	if status < Ready || status > Failed {
+		return incompatible("unknown status " + strconv.Itoa(int(status)))
+	}
+	for _, fr := range stack {
+		if fr.fn >= len(m.Funcs) || fr.callPos >= len(m.OpAddrs) || !m.opStarts[fr.callPos] ||
+			m.OpAddrs[fr.callPos] != iopCall || m.OpAddrs[fr.callPos+1] != fr.fn {
+			return incompatible("call frame does not return to a call")
+		}
+		frame := m.Funcs[fr.fn].Frame
+		lens := []int{len(fr.ints), len(fr.strs), len(fr.bools), len(fr.intArrs), len(fr.strArrs), len(fr.boolArrs), len(fr.floats)}
+		for i, r := range frame.ranges() {
+			if lens[i] != r.End-r.Start {
+				return incompatible("call frame of '" + m.Funcs[fr.fn].Name + "' does not match its function")
+			}
+		}
+	}
+	return nil
+}
+
+// layoutHash identifies the ops and slot layout of bc, which a snapshot can
+// only be restored into unchanged.
+func (bc *Bytecode) layoutHash() uint64 {
+	var buf []byte
+	for _, v := range bc.OpAddrs {
+		buf = binary.AppendVarint(buf, int64(v))
+	}
+	for _, typ := range []Type{Int, Str, Bool, Float, ArrInt, ArrStr, ArrBool} {
+		buf = binary.AppendUvarint(buf, uint64(bc.poolLen(typ)))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(bc.Funcs)))
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64()
+}
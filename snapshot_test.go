@@ -0,0 +1,130 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// snapshotSrc yields inside a function, so that a snapshot taken at a yield
+// has a call on the stack.
+const snapshotSrc = `n
+out total
+total = 0
+words = []
+func step x
+  y = x * 2
+  yield
+  return y
+end
+for i from 1 to n
+  d = step i
+  total = total + d
+  words = append words (str d)
+  print total
+end
+`
+
+func compileSnapshotSrc(t *testing.T) *Program {
+	t.Helper()
+	prog, err := Compile(strings.NewReader(snapshotSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestSnapshotResume(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	m := compileSnapshotSrc(t).NewMachine()
+	if err := m.SetInputs(map[string]any{"n": 4}); err != nil {
+		t.Fatal(err)
+	}
+	// Each run stops at a yield and is carried on by a machine of another
+	// compile of the script, as it would be in another process.
+	yields := 0
+	err := m.Run(ctx, Options{Output: &out})
+	for ; !m.Done(); err = m.Resume(ctx, Options{Output: &out}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Status() != Suspended || len(m.stack) != 1 {
+			t.Fatalf("stopped %v with %d calls, want suspended in step", m.Status(), len(m.stack))
+		}
+		data, err := m.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m = compileSnapshotSrc(t).NewMachine()
+		if err := m.Restore(data); err != nil {
+			t.Fatal(err)
+		}
+		if m.Status() != Suspended {
+			t.Fatalf("restored machine is %v", m.Status())
+		}
+		yields++
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yields != 4 {
+		t.Errorf("yielded %d times, want 4", yields)
+	}
+	if got := out.String(); got != "2\n6\n12\n20\n" {
+		t.Errorf("printed %q", got)
+	}
+	if got := m.Outputs()["total"]; got != 20 {
+		t.Errorf("total = %v, want 20", got)
+	}
+	if got := m.StrArrs; len(got) != 1 || strings.Join(got[0], " ") != "2 4 6 8" {
+		t.Errorf("words = %v", got)
+	}
+}
+
+func TestRestoreRejects(t *testing.T) {
+	ctx := context.Background()
+	m := compileSnapshotSrc(t).NewMachine()
+	if err := m.SetInputs(map[string]any{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(ctx, Options{Output: new(bytes.Buffer)}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	optimized := compileSnapshotSrc(t)
+	if err := optimized.Optimize(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		prog         *Program
+		data         []byte
+		incompatible bool
+	}{
+		{"optimized program", optimized, data, true},
+		{"bad magic", compileSnapshotSrc(t), append([]byte("XX"), data[2:]...), false},
+		{"truncated", compileSnapshotSrc(t), data[:len(data)-1], false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := test.prog.NewMachine()
+			err := m.Restore(test.data)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if errors.Is(err, ErrIncompatibleSnapshot) != test.incompatible {
+				t.Errorf("error %q, want ErrIncompatibleSnapshot: %v", err, test.incompatible)
+			}
+			if m.Status() != Ready || m.pos != 0 {
+				t.Errorf("failed restore left the machine %v at %d", m.Status(), m.pos)
+			}
+		})
+	}
+}
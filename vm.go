@@ -1,15 +1,165 @@
 package ez
 
-import "log"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
 
-func Run(p *Bytecode) {
+var (
+	ErrUnknownOpcode   = errors.New("unknown opcode")
+	ErrBadAddress      = errors.New("operand address out of bounds")
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrMemoryLimit     = errors.New("memory limit exceeded")
+	ErrStackOverflow   = errors.New("call stack overflow")
+	ErrMissingReturn   = errors.New("function ended without return")
+	ErrConversion      = errors.New("invalid conversion")
+	ErrSuspended       = errors.New("suspended at yield")
+)
+
+type RuntimeError struct {
+	Pos    int    // index of the failing op in OpAddrs
+	Op     string // opcode name, empty if the opcode is unknown
+	Line   uint16 // source line, 0 if the bytecode carries no line marks
+	Err    error
+	Detail string
+}
+
+func (e *RuntimeError) Error() string {
+	msg := "RUNTIME ERROR - "
+	if e.Line > 0 {
+		msg += "line " + strconv.Itoa(int(e.Line)) + ", "
+	}
+	msg += "op " + strconv.Itoa(e.Pos)
+	if e.Op != "" {
+		msg += " '" + e.Op + "'"
+	}
+	msg += ": " + e.Err.Error()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+type Options struct {
+	MaxSteps  int // ops executed before ErrStepLimit, 0 for no limit
+	MaxMemory int // approximate bytes held by all pools before ErrMemoryLimit, 0 for no limit
+
+	// MaxCallDepth bounds nested function calls before ErrStackOverflow.
+	// Defaults to DefaultMaxCallDepth.
+	MaxCallDepth int
+
+	// Output receives everything the script prints. Defaults to os.Stdout.
+	Output io.Writer
+
+	// Env supplies the host functions the bytecode calls. Programs compiled
+	// with an Env default to it.
+	Env *Env
+
+	// Profile, if set, accumulates the executions and time of every op run.
+	Profile *Profile
+
+	// stop is asked before every op but the first whether to pause there,
+	// in which case the run returns errPaused and may be resumed.
+	stop func(pos int) bool
+}
+
+var errPaused = errors.New("paused")
+
+// ctxCheckInterval is how many ops run between checks of ctx.Done().
+const ctxCheckInterval = 1024
+
+// Run runs p on a new Machine, leaving p as it was.
+func Run(p *Bytecode) error {
+	return RunWithOptions(context.Background(), p, Options{})
+}
+
+// RunWithOptions runs p on a new Machine, leaving p as it was. A script that
+// yields fails with ErrSuspended, as there is no machine to resume.
+func RunWithOptions(ctx context.Context, p *Bytecode, opts Options) error {
+	m := NewMachine(p)
+	if err := m.Run(ctx, opts); err != nil {
+		return err
+	}
+	return m.finished()
+}
+
+func (p *Machine) run(ctx context.Context, opts Options) error {
+	if p.opStarts == nil {
+		opStarts, err := verify(&p.Bytecode)
+		if err != nil {
+			return err
+		}
+		p.opStarts = opStarts
+	}
+	opStarts := p.opStarts
+	if p.hosts == nil || opts.Env != p.hostEnv {
+		hosts, err := p.bindHosts(opts.Env)
+		if err != nil {
+			return err
+		}
+		// An Env cannot replace a function once registered, so what was
+		// bound stays right for later runs with the same Env.
+		p.hosts, p.hostEnv = hosts, opts.Env
+	}
+	hosts := p.hosts
+	mem := p.memUsage()
+	if opts.MaxMemory > 0 && mem > opts.MaxMemory {
+		return p.runtimeErr(ErrMemoryLimit, memDetail(mem, opts.MaxMemory))
+	}
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	maxCallDepth := opts.MaxCallDepth
+	if maxCallDepth == 0 {
+		maxCallDepth = DefaultMaxCallDepth
+	}
+	done := ctx.Done()
+	prof := opts.Profile
+	if prof != nil {
+		prof.begin(&p.Bytecode)
+		defer prof.end()
+	}
+	var steps int
 	for p.pos < len(p.OpAddrs) {
+		if opts.stop != nil && steps > 0 && opts.stop(p.pos) {
+			return errPaused
+		}
+		steps++
+		if opts.MaxSteps > 0 && steps > opts.MaxSteps {
+			return p.runtimeErr(ErrStepLimit, strconv.Itoa(opts.MaxSteps)+" steps")
+		}
+		if done != nil && steps%ctxCheckInterval == 0 {
+			select {
+			case <-done:
+				return p.runtimeErr(ctx.Err(), "")
+			default:
+			}
+		}
+		if prof != nil {
+			prof.enter(p.pos)
+		}
 		switch p.OpAddrs[p.pos] {
 		case 0: // 0: iopIntCopy (int int)
 			p.Ints[p.OpAddrs[p.pos+2]] = p.Ints[p.OpAddrs[p.pos+1]]
 			p.pos += 3
 		case 1: // 1: iopStrCopy (str str)
-			p.Strs[p.OpAddrs[p.pos+2]] = p.Strs[p.OpAddrs[p.pos+1]]
+			dst, str := p.OpAddrs[p.pos+2], p.Strs[p.OpAddrs[p.pos+1]]
+			if err := p.charge(&mem, len(str)-len(p.Strs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.Strs[dst] = str
 			p.pos += 3
 		case 2: // 2: iopBoolCopy (bool bool)
 			p.Bools[p.OpAddrs[p.pos+2]] = p.Bools[p.OpAddrs[p.pos+1]]
@@ -21,6 +171,9 @@ func Run(p *Bytecode) {
 			p.Bools[p.OpAddrs[p.pos+3]] = p.Strs[p.OpAddrs[p.pos+1]] != p.Strs[p.OpAddrs[p.pos+2]]
 			p.pos += 4
 		case 5: // 5: % (int int) -> int
+			if p.Ints[p.OpAddrs[p.pos+2]] == 0 {
+				return p.runtimeErr(ErrDivisionByZero, p.operandName(2))
+			}
 			p.Ints[p.OpAddrs[p.pos+3]] = p.Ints[p.OpAddrs[p.pos+1]] % p.Ints[p.OpAddrs[p.pos+2]]
 			p.pos += 4
 		case 6: // 6: && (bool bool) -> bool
@@ -33,12 +186,19 @@ func Run(p *Bytecode) {
 			p.Ints[p.OpAddrs[p.pos+3]] = p.Ints[p.OpAddrs[p.pos+1]] + p.Ints[p.OpAddrs[p.pos+2]]
 			p.pos += 4
 		case 9: // 9: + (str str) -> str
-			p.Strs[p.OpAddrs[p.pos+3]] = p.Strs[p.OpAddrs[p.pos+1]] + p.Strs[p.OpAddrs[p.pos+2]]
+			dst, str := p.OpAddrs[p.pos+3], p.Strs[p.OpAddrs[p.pos+1]]+p.Strs[p.OpAddrs[p.pos+2]]
+			if err := p.charge(&mem, len(str)-len(p.Strs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.Strs[dst] = str
 			p.pos += 4
 		case 10: // 10: - (int int) -> int
 			p.Ints[p.OpAddrs[p.pos+3]] = p.Ints[p.OpAddrs[p.pos+1]] - p.Ints[p.OpAddrs[p.pos+2]]
 			p.pos += 4
 		case 11: // 11: / (int int) -> int
+			if p.Ints[p.OpAddrs[p.pos+2]] == 0 {
+				return p.runtimeErr(ErrDivisionByZero, p.operandName(2))
+			}
 			p.Ints[p.OpAddrs[p.pos+3]] = p.Ints[p.OpAddrs[p.pos+1]] / p.Ints[p.OpAddrs[p.pos+2]]
 			p.pos += 4
 		case 12: // 12: < (int int) -> bool
@@ -60,7 +220,11 @@ func Run(p *Bytecode) {
 			p.Bools[p.OpAddrs[p.pos+3]] = p.Ints[p.OpAddrs[p.pos+1]] >= p.Ints[p.OpAddrs[p.pos+2]]
 			p.pos += 4
 		case 18: // 18: goto (addr)
-			p.pos = p.Ints[p.OpAddrs[p.pos+1]]
+			target := p.Ints[p.OpAddrs[p.pos+1]]
+			if target < 0 || target >= len(opStarts) || !opStarts[target] {
+				return p.runtimeErr(ErrBadAddress, "jump target "+strconv.Itoa(target))
+			}
+			p.pos = target
 		case 19: // 19: if (bool)
 			if p.Bools[p.OpAddrs[p.pos+1]] {
 				p.pos += 3
@@ -68,17 +232,464 @@ func Run(p *Bytecode) {
 				p.pos = p.OpAddrs[p.pos+2]
 			}
 		case 20: // 20: print (str)
-			log.Println(p.Strs[p.OpAddrs[p.pos+1]])
+			if _, err := fmt.Fprintln(out, p.Strs[p.OpAddrs[p.pos+1]]); err != nil {
+				return p.runtimeErr(err, "")
+			}
 			p.pos += 2
 		case 21: // 21: print (int)
-			log.Println(p.Ints[p.OpAddrs[p.pos+1]])
+			if _, err := fmt.Fprintln(out, p.Ints[p.OpAddrs[p.pos+1]]); err != nil {
+				return p.runtimeErr(err, "")
+			}
 			p.pos += 2
 		case 22: // 22: print (bool)
-			log.Println(p.Bools[p.OpAddrs[p.pos+1]])
+			if _, err := fmt.Fprintln(out, p.Bools[p.OpAddrs[p.pos+1]]); err != nil {
+				return p.runtimeErr(err, "")
+			}
 			p.pos += 2
 		case 23: // 23: || (bool bool) -> bool
 			p.Bools[p.OpAddrs[p.pos+3]] = p.Bools[p.OpAddrs[p.pos+1]] || p.Bools[p.OpAddrs[p.pos+2]]
 			p.pos += 4
+		case 24: // 24: iopIntArrCopy ([int] [int])
+			src, dst := p.OpAddrs[p.pos+1], p.OpAddrs[p.pos+2]
+			if err := p.charge(&mem, 8*len(p.IntArrs[src])-8*len(p.IntArrs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.IntArrs[dst] = append([]int(nil), p.IntArrs[src]...)
+			p.pos += 3
+		case 25: // 25: iopStrArrCopy ([str] [str])
+			src, dst := p.OpAddrs[p.pos+1], p.OpAddrs[p.pos+2]
+			if err := p.charge(&mem, strsSize(p.StrArrs[src])-strsSize(p.StrArrs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.StrArrs[dst] = append([]string(nil), p.StrArrs[src]...)
+			p.pos += 3
+		case 26: // 26: iopBoolArrCopy ([bool] [bool])
+			src, dst := p.OpAddrs[p.pos+1], p.OpAddrs[p.pos+2]
+			if err := p.charge(&mem, len(p.BoolArrs[src])-len(p.BoolArrs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.BoolArrs[dst] = append([]bool(nil), p.BoolArrs[src]...)
+			p.pos += 3
+		case 27: // 27: iopIntArrClear ([int])
+			dst := p.OpAddrs[p.pos+1]
+			mem -= 8 * len(p.IntArrs[dst])
+			p.IntArrs[dst] = nil
+			p.pos += 2
+		case 28: // 28: iopStrArrClear ([str])
+			dst := p.OpAddrs[p.pos+1]
+			mem -= strsSize(p.StrArrs[dst])
+			p.StrArrs[dst] = nil
+			p.pos += 2
+		case 29: // 29: iopBoolArrClear ([bool])
+			dst := p.OpAddrs[p.pos+1]
+			mem -= len(p.BoolArrs[dst])
+			p.BoolArrs[dst] = nil
+			p.pos += 2
+		case 30: // 30: append ([int] int) -> [int]
+			src, dst, val := p.OpAddrs[p.pos+1], p.OpAddrs[p.pos+3], p.Ints[p.OpAddrs[p.pos+2]]
+			if src == dst {
+				if err := p.charge(&mem, 8, opts.MaxMemory); err != nil {
+					return err
+				}
+				p.IntArrs[dst] = append(p.IntArrs[src], val)
+			} else {
+				if err := p.charge(&mem, 8*len(p.IntArrs[src])+8-8*len(p.IntArrs[dst]), opts.MaxMemory); err != nil {
+					return err
+				}
+				p.IntArrs[dst] = append(append(make([]int, 0, len(p.IntArrs[src])+1), p.IntArrs[src]...), val)
+			}
+			p.pos += 4
+		case 31: // 31: append ([str] str) -> [str]
+			src, dst, val := p.OpAddrs[p.pos+1], p.OpAddrs[p.pos+3], p.Strs[p.OpAddrs[p.pos+2]]
+			if src == dst {
+				if err := p.charge(&mem, 16+len(val), opts.MaxMemory); err != nil {
+					return err
+				}
+				p.StrArrs[dst] = append(p.StrArrs[src], val)
+			} else {
+				if err := p.charge(&mem, strsSize(p.StrArrs[src])+16+len(val)-strsSize(p.StrArrs[dst]), opts.MaxMemory); err != nil {
+					return err
+				}
+				p.StrArrs[dst] = append(append(make([]string, 0, len(p.StrArrs[src])+1), p.StrArrs[src]...), val)
+			}
+			p.pos += 4
+		case 32: // 32: append ([bool] bool) -> [bool]
+			src, dst, val := p.OpAddrs[p.pos+1], p.OpAddrs[p.pos+3], p.Bools[p.OpAddrs[p.pos+2]]
+			if src == dst {
+				if err := p.charge(&mem, 1, opts.MaxMemory); err != nil {
+					return err
+				}
+				p.BoolArrs[dst] = append(p.BoolArrs[src], val)
+			} else {
+				if err := p.charge(&mem, len(p.BoolArrs[src])+1-len(p.BoolArrs[dst]), opts.MaxMemory); err != nil {
+					return err
+				}
+				p.BoolArrs[dst] = append(append(make([]bool, 0, len(p.BoolArrs[src])+1), p.BoolArrs[src]...), val)
+			}
+			p.pos += 4
+		case 33: // 33: get ([int] int) -> int
+			arr, i := p.IntArrs[p.OpAddrs[p.pos+1]], p.Ints[p.OpAddrs[p.pos+2]]
+			if i < 0 || i >= len(arr) {
+				return p.runtimeErr(ErrIndexOutOfRange, p.indexDetail(i, len(arr)))
+			}
+			p.Ints[p.OpAddrs[p.pos+3]] = arr[i]
+			p.pos += 4
+		case 34: // 34: get ([str] int) -> str
+			arr, i := p.StrArrs[p.OpAddrs[p.pos+1]], p.Ints[p.OpAddrs[p.pos+2]]
+			if i < 0 || i >= len(arr) {
+				return p.runtimeErr(ErrIndexOutOfRange, p.indexDetail(i, len(arr)))
+			}
+			dst := p.OpAddrs[p.pos+3]
+			if err := p.charge(&mem, len(arr[i])-len(p.Strs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.Strs[dst] = arr[i]
+			p.pos += 4
+		case 35: // 35: get ([bool] int) -> bool
+			arr, i := p.BoolArrs[p.OpAddrs[p.pos+1]], p.Ints[p.OpAddrs[p.pos+2]]
+			if i < 0 || i >= len(arr) {
+				return p.runtimeErr(ErrIndexOutOfRange, p.indexDetail(i, len(arr)))
+			}
+			p.Bools[p.OpAddrs[p.pos+3]] = arr[i]
+			p.pos += 4
+		case 36: // 36: len ([int]) -> int
+			p.Ints[p.OpAddrs[p.pos+2]] = len(p.IntArrs[p.OpAddrs[p.pos+1]])
+			p.pos += 3
+		case 37: // 37: len ([str]) -> int
+			p.Ints[p.OpAddrs[p.pos+2]] = len(p.StrArrs[p.OpAddrs[p.pos+1]])
+			p.pos += 3
+		case 38: // 38: len ([bool]) -> int
+			p.Ints[p.OpAddrs[p.pos+2]] = len(p.BoolArrs[p.OpAddrs[p.pos+1]])
+			p.pos += 3
+		case 39: // 39: set ([int] int int)
+			arr, i := p.IntArrs[p.OpAddrs[p.pos+1]], p.Ints[p.OpAddrs[p.pos+2]]
+			if i < 0 || i >= len(arr) {
+				return p.runtimeErr(ErrIndexOutOfRange, p.indexDetail(i, len(arr)))
+			}
+			arr[i] = p.Ints[p.OpAddrs[p.pos+3]]
+			p.pos += 4
+		case 40: // 40: set ([str] int str)
+			arr, i := p.StrArrs[p.OpAddrs[p.pos+1]], p.Ints[p.OpAddrs[p.pos+2]]
+			if i < 0 || i >= len(arr) {
+				return p.runtimeErr(ErrIndexOutOfRange, p.indexDetail(i, len(arr)))
+			}
+			str := p.Strs[p.OpAddrs[p.pos+3]]
+			if err := p.charge(&mem, len(str)-len(arr[i]), opts.MaxMemory); err != nil {
+				return err
+			}
+			arr[i] = str
+			p.pos += 4
+		case 41: // 41: set ([bool] int bool)
+			arr, i := p.BoolArrs[p.OpAddrs[p.pos+1]], p.Ints[p.OpAddrs[p.pos+2]]
+			if i < 0 || i >= len(arr) {
+				return p.runtimeErr(ErrIndexOutOfRange, p.indexDetail(i, len(arr)))
+			}
+			arr[i] = p.Bools[p.OpAddrs[p.pos+3]]
+			p.pos += 4
+		case 42: // 42: print ([int])
+			if _, err := fmt.Fprintln(out, p.IntArrs[p.OpAddrs[p.pos+1]]); err != nil {
+				return p.runtimeErr(err, "")
+			}
+			p.pos += 2
+		case 43: // 43: print ([str])
+			if _, err := fmt.Fprintln(out, p.StrArrs[p.OpAddrs[p.pos+1]]); err != nil {
+				return p.runtimeErr(err, "")
+			}
+			p.pos += 2
+		case 44: // 44: print ([bool])
+			if _, err := fmt.Fprintln(out, p.BoolArrs[p.OpAddrs[p.pos+1]]); err != nil {
+				return p.runtimeErr(err, "")
+			}
+			p.pos += 2
+		case 45: // 45: call (func args... outs...)
+			if len(p.stack) >= maxCallDepth {
+				return p.runtimeErr(ErrStackOverflow, strconv.Itoa(maxCallDepth)+" nested calls")
+			}
+			callPos := p.pos
+			if err := p.charge(&mem, p.call(), opts.MaxMemory); err != nil {
+				p.pos = callPos
+				return err
+			}
+		case 46: // 46: return (func vals...)
+			if len(p.stack) == 0 || p.stack[len(p.stack)-1].fn != p.OpAddrs[p.pos+1] {
+				return p.runtimeErr(ErrBadAddress, "return without matching call")
+			}
+			mem -= p.ret()
+		case 47: // 47: missingreturn (func)
+			return p.runtimeErr(ErrMissingReturn, p.Funcs[p.OpAddrs[p.pos+1]].Name)
+		case 48: // 48: iopFloatCopy (float float)
+			p.Floats[p.OpAddrs[p.pos+2]] = p.Floats[p.OpAddrs[p.pos+1]]
+			p.pos += 3
+		case 49: // 49: != (float float) -> bool
+			p.Bools[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] != p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 50: // 50: % (float float) -> float
+			if p.Floats[p.OpAddrs[p.pos+2]] == 0 {
+				return p.runtimeErr(ErrDivisionByZero, p.operandName(2))
+			}
+			p.Floats[p.OpAddrs[p.pos+3]] = math.Mod(p.Floats[p.OpAddrs[p.pos+1]], p.Floats[p.OpAddrs[p.pos+2]])
+			p.pos += 4
+		case 51: // 51: * (float float) -> float
+			p.Floats[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] * p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 52: // 52: + (float float) -> float
+			p.Floats[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] + p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 53: // 53: - (float float) -> float
+			p.Floats[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] - p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 54: // 54: / (float float) -> float
+			if p.Floats[p.OpAddrs[p.pos+2]] == 0 {
+				return p.runtimeErr(ErrDivisionByZero, p.operandName(2))
+			}
+			p.Floats[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] / p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 55: // 55: < (float float) -> bool
+			p.Bools[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] < p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 56: // 56: <= (float float) -> bool
+			p.Bools[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] <= p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 57: // 57: == (float float) -> bool
+			p.Bools[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] == p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 58: // 58: > (float float) -> bool
+			p.Bools[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] > p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 59: // 59: >= (float float) -> bool
+			p.Bools[p.OpAddrs[p.pos+3]] = p.Floats[p.OpAddrs[p.pos+1]] >= p.Floats[p.OpAddrs[p.pos+2]]
+			p.pos += 4
+		case 60: // 60: print (float)
+			if _, err := fmt.Fprintln(out, formatFloat(p.Floats[p.OpAddrs[p.pos+1]])); err != nil {
+				return p.runtimeErr(err, "")
+			}
+			p.pos += 2
+		case 61: // 61: float (int) -> float
+			p.Floats[p.OpAddrs[p.pos+2]] = float64(p.Ints[p.OpAddrs[p.pos+1]])
+			p.pos += 3
+		case 62: // 62: float (str) -> float
+			str := p.Strs[p.OpAddrs[p.pos+1]]
+			f, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return p.runtimeErr(ErrConversion, strconv.Quote(str)+" is not a float")
+			}
+			p.Floats[p.OpAddrs[p.pos+2]] = f
+			p.pos += 3
+		case 63: // 63: int (float) -> int
+			f := p.Floats[p.OpAddrs[p.pos+1]]
+			if f != f || f >= 1<<63 || f < -(1<<63) {
+				return p.runtimeErr(ErrConversion, formatFloat(f)+" does not fit in an int")
+			}
+			p.Ints[p.OpAddrs[p.pos+2]] = int(f)
+			p.pos += 3
+		case 64: // 64: int (str) -> int
+			str := p.Strs[p.OpAddrs[p.pos+1]]
+			i, err := strconv.Atoi(str)
+			if err != nil {
+				return p.runtimeErr(ErrConversion, strconv.Quote(str)+" is not an int")
+			}
+			p.Ints[p.OpAddrs[p.pos+2]] = i
+			p.pos += 3
+		case 65: // 65: str (int) -> str
+			dst, str := p.OpAddrs[p.pos+2], strconv.Itoa(p.Ints[p.OpAddrs[p.pos+1]])
+			if err := p.charge(&mem, len(str)-len(p.Strs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.Strs[dst] = str
+			p.pos += 3
+		case 66: // 66: str (float) -> str
+			dst, str := p.OpAddrs[p.pos+2], formatFloat(p.Floats[p.OpAddrs[p.pos+1]])
+			if err := p.charge(&mem, len(str)-len(p.Strs[dst]), opts.MaxMemory); err != nil {
+				return err
+			}
+			p.Strs[dst] = str
+			p.pos += 3
+		case 67: // 67: hostcall (host args... outs...)
+			delta, err := p.hostCall(hosts)
+			if err != nil {
+				return err
+			}
+			if err := p.charge(&mem, delta, opts.MaxMemory); err != nil {
+				return err
+			}
+		case 68: // 68: yield
+			p.pos++
+			return nil
+		default:
+			return p.runtimeErr(ErrUnknownOpcode, strconv.Itoa(p.OpAddrs[p.pos]))
+		}
+	}
+	return nil
+}
+
+// verify checks every opcode and operand address before anything runs, so
+// the dispatch loop only needs to guard values computed at runtime. It
+// returns which positions start an op; the end of the program counts as one.
+func verify(p *Bytecode) ([]bool, error) {
+	opStarts := make([]bool, len(p.OpAddrs)+1)
+	opStarts[len(p.OpAddrs)] = true
+	var jumps [][2]int // op position, operand position
+	for pos := 0; pos < len(p.OpAddrs); {
+		args, ok := p.opArgs(pos)
+		if !ok {
+			return nil, p.runtimeErrAt(pos, ErrUnknownOpcode, strconv.Itoa(p.OpAddrs[pos]))
+		}
+		opStarts[pos] = true
+		if pos+len(args) >= len(p.OpAddrs) {
+			return nil, p.runtimeErrAt(pos, ErrBadAddress, "op is truncated")
+		}
+		for i, typ := range args {
+			addr := p.OpAddrs[pos+1+i]
+			if typ == jumpPos {
+				jumps = append(jumps, [2]int{pos, pos + 1 + i})
+				continue
+			}
+			if addr < 0 || addr >= p.poolLen(typ) {
+				return nil, p.runtimeErrAt(pos, ErrBadAddress, typ.String()+" slot "+strconv.Itoa(addr))
+			}
+		}
+		pos += 1 + len(args)
+	}
+	for _, jump := range jumps {
+		target := p.OpAddrs[jump[1]]
+		if target < 0 || target >= len(opStarts) || !opStarts[target] {
+			return nil, p.runtimeErrAt(jump[0], ErrBadAddress, "jump target "+strconv.Itoa(target))
 		}
 	}
+	for _, fn := range p.Funcs {
+		if err := p.verifyFunc(fn, opStarts); err != nil {
+			return nil, err
+		}
+	}
+	return opStarts, nil
+}
+
+func (p *Bytecode) verifyFunc(fn FuncInfo, opStarts []bool) error {
+	bad := func(detail string) error {
+		return &RuntimeError{Pos: fn.Entry, Err: ErrBadAddress, Detail: "function '" + fn.Name + "': " + detail}
+	}
+	if fn.Entry < 0 || fn.Entry >= len(opStarts) || !opStarts[fn.Entry] {
+		return bad("entry " + strconv.Itoa(fn.Entry))
+	}
+	if len(fn.Params) != len(fn.In) {
+		return bad("parameter count")
+	}
+	for i, typ := range fn.In {
+		if fn.Params[i] < 0 || fn.Params[i] >= p.poolLen(typ) {
+			return bad(typ.String() + " slot " + strconv.Itoa(fn.Params[i]))
+		}
+	}
+	ranges := []struct {
+		r   SlotRange
+		typ Type
+	}{
+		{fn.Frame.Ints, Int}, {fn.Frame.Strs, Str}, {fn.Frame.Bools, Bool},
+		{fn.Frame.IntArrs, ArrInt}, {fn.Frame.StrArrs, ArrStr}, {fn.Frame.BoolArrs, ArrBool},
+		{fn.Frame.Floats, Float},
+	}
+	for _, rng := range ranges {
+		if rng.r.Start < 0 || rng.r.Start > rng.r.End || rng.r.End > p.poolLen(rng.typ) {
+			return bad(rng.typ.String() + " frame")
+		}
+	}
+	return nil
+}
+
+func (p *Bytecode) poolLen(typ Type) int {
+	switch typ {
+	case funcRef:
+		return len(p.Funcs)
+	case hostRef:
+		return len(p.Hosts)
+	case Int, Addr:
+		return len(p.Ints)
+	case Str:
+		return len(p.Strs)
+	case Bool:
+		return len(p.Bools)
+	case Float:
+		return len(p.Floats)
+	case ArrInt:
+		return len(p.IntArrs)
+	case ArrStr:
+		return len(p.StrArrs)
+	case ArrBool:
+		return len(p.BoolArrs)
+	}
+	return 0
+}
+
+// memUsage approximates the bytes held by the pools: 8 per int or float, 1 per
+// bool and a 16 byte header plus contents per string.
+func (p *Bytecode) memUsage() int {
+	mem := 8*len(p.Ints) + strsSize(p.Strs) + len(p.Bools) + 8*len(p.Floats)
+	for _, arr := range p.IntArrs {
+		mem += 8 * len(arr)
+	}
+	for _, arr := range p.StrArrs {
+		mem += strsSize(arr)
+	}
+	for _, arr := range p.BoolArrs {
+		mem += len(arr)
+	}
+	return mem
+}
+
+// formatFloat renders f in the shortest form that parses back to it.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func strsSize(strs []string) int {
+	size := 16 * len(strs)
+	for _, str := range strs {
+		size += len(str)
+	}
+	return size
+}
+
+func (p *Machine) charge(mem *int, delta, limit int) error {
+	*mem += delta
+	if limit > 0 && *mem > limit {
+		return p.runtimeErr(ErrMemoryLimit, memDetail(*mem, limit))
+	}
+	return nil
+}
+
+func memDetail(mem, limit int) string {
+	return strconv.Itoa(mem) + " bytes in use, limit " + strconv.Itoa(limit)
+}
+
+func (p *Machine) runtimeErr(err error, detail string) error {
+	return p.runtimeErrAt(p.pos, err, detail)
+}
+
+func (p *Bytecode) runtimeErrAt(pos int, err error, detail string) error {
+	rerr := &RuntimeError{Pos: pos, Line: p.lineAt(pos), Err: err, Detail: detail}
+	if pos < len(p.OpAddrs) {
+		if op := p.OpAddrs[pos]; op >= 0 && op < len(opcodes) {
+			rerr.Op = opcodes[op].name
+		}
+	}
+	return rerr
+}
+
+// indexDetail describes an out of range index into the array that is the
+// first operand of the op at p.pos.
+func (p *Machine) indexDetail(i, length int) string {
+	detail := "index " + strconv.Itoa(i) + ", length " + strconv.Itoa(length)
+	if name := p.operandName(1); name != "" {
+		detail = name + " " + detail
+	}
+	return detail
+}
+
+// operandName returns the quoted source name of operand n (1-based) of the
+// op at p.pos, empty if the bytecode carries no name for it.
+func (p *Machine) operandName(n int) string {
+	name := p.slotName(opcodes[p.OpAddrs[p.pos]].args[n-1], p.OpAddrs[p.pos+n])
+	if name == "" {
+		return ""
+	}
+	return "'" + name + "'"
 }
@@ -0,0 +1,187 @@
+package ez
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type runtimeErrTest struct {
+	name   string
+	src    string
+	err    error
+	line   uint16
+	detail string
+}
+
+// checkRuntimeErrs runs each test's script and checks that it fails with a
+// RuntimeError wrapping the test's error, on its line and with its detail.
+func checkRuntimeErrs(t *testing.T, tests []runtimeErrTest) {
+	t.Helper()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, err := Parse(strings.NewReader(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = RunWithOptions(context.Background(), &bc, Options{Output: new(bytes.Buffer)})
+			checkRuntimeErr(t, err, test)
+		})
+	}
+}
+
+func checkRuntimeErr(t *testing.T, err error, want runtimeErrTest) {
+	t.Helper()
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("error %v, want a RuntimeError", err)
+	}
+	if !errors.Is(err, want.err) || rerr.Line != want.line || rerr.Detail != want.detail {
+		t.Errorf("got %q on line %d with detail %q, want %q on line %d with detail %q", rerr.Err, rerr.Line, rerr.Detail, want.err, want.line, want.detail)
+	}
+}
+
+func TestArrayIndexErrors(t *testing.T) {
+	checkRuntimeErrs(t, []runtimeErrTest{
+		{"get past the end", "nums = [1 2]\nx = get nums 2\n", ErrIndexOutOfRange, 2, "'nums' index 2, length 2"},
+		{"get negative", "words = ['a']\ni = 0 - 1\nw = get words i\n", ErrIndexOutOfRange, 3, "'words' index -1, length 1"},
+		{"get from empty", "flags = []\nok = true\nflags = append flags ok\nflags = []\nf = get flags 0\n", ErrIndexOutOfRange, 5, "'flags' index 0, length 0"},
+		{"set past the end", "nums = [1 2]\nset nums 5 1\n", ErrIndexOutOfRange, 2, "'nums' index 5, length 2"},
+		{"set in a function", "func f a\n  set a 3 'x'\n  return a\nend\nw = ['a']\nw = f w\n", ErrIndexOutOfRange, 2, "'a' index 3, length 1"},
+	})
+}
+
+func TestConversionErrors(t *testing.T) {
+	checkRuntimeErrs(t, []runtimeErrTest{
+		{"float of a word", "s = 'abc'\nf = float s\n", ErrConversion, 2, `"abc" is not a float`},
+		{"float of an empty string", "f = float ''\n", ErrConversion, 1, `"" is not a float`},
+		{"int of a float string", "s = '1.5'\ni = int s\n", ErrConversion, 2, `"1.5" is not an int`},
+		{"int of a huge float", "big = 10000000000.0 * 10000000000.0\nbig = big * big\ni = int big\n", ErrConversion, 3, "1e+40 does not fit in an int"},
+		{"int of a NaN", "i = int (float 'NaN')\n", ErrConversion, 1, "NaN does not fit in an int"},
+	})
+}
+
+func TestDivisionByZero(t *testing.T) {
+	checkRuntimeErrs(t, []runtimeErrTest{
+		{"int divide", "a = 7\nb = 0\nc = a / b\n", ErrDivisionByZero, 3, "'b'"},
+		{"int modulo", "a = 7\nb = 0\nc = a % b\n", ErrDivisionByZero, 3, "'b'"},
+		{"float divide", "x = 1.5\ny = 0.0\nz = x / y\n", ErrDivisionByZero, 3, "'y'"},
+		{"float modulo", "x = 1.5\ny = 0.0\nz = x % y\n", ErrDivisionByZero, 3, "'y'"},
+		{"divide by a temporary", "a = 7\nc = a / (a - 7)\n", ErrDivisionByZero, 2, ""},
+	})
+}
+
+// verifySrc has an op of each kind the cases of TestVerify break: the '+'
+// on line 3 and the jump of the if on line 4.
+const verifySrc = `a = 1
+ok = true
+b = a + 2
+if ok
+  print b
+end
+`
+
+func TestVerify(t *testing.T) {
+	bc, err := Parse(strings.NewReader(verifySrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineStart := func(bc *Bytecode, line uint16) int {
+		for _, mark := range bc.Lines {
+			if mark.Line == line {
+				return mark.Pos
+			}
+		}
+		t.Fatalf("no ops on line %d", line)
+		return 0
+	}
+	tests := []struct {
+		runtimeErrTest
+		corrupt func(bc *Bytecode)
+	}{
+		{
+			runtimeErrTest{name: "unknown opcode", err: ErrUnknownOpcode, line: 3, detail: "9999"},
+			func(bc *Bytecode) { bc.OpAddrs[lineStart(bc, 3)] = 9999 },
+		},
+		{
+			runtimeErrTest{name: "bad operand", err: ErrBadAddress, line: 3, detail: "int slot 1000"},
+			func(bc *Bytecode) { bc.OpAddrs[lineStart(bc, 3)+1] = 1000 },
+		},
+		{
+			runtimeErrTest{name: "negative operand", err: ErrBadAddress, line: 3, detail: "int slot -1"},
+			func(bc *Bytecode) { bc.OpAddrs[lineStart(bc, 3)+3] = -1 },
+		},
+		{
+			runtimeErrTest{name: "bad jump", err: ErrBadAddress, line: 4, detail: "jump target 1000"},
+			func(bc *Bytecode) {
+				pos := lineStart(bc, 4)
+				args, _ := bc.opArgs(pos)
+				bc.OpAddrs[pos+len(args)] = 1000
+			},
+		},
+		{
+			runtimeErrTest{name: "jump into an op", err: ErrBadAddress, line: 4, detail: "jump target 1"},
+			func(bc *Bytecode) {
+				pos := lineStart(bc, 4)
+				args, _ := bc.opArgs(pos)
+				bc.OpAddrs[pos+len(args)] = 1
+			},
+		},
+		{
+			runtimeErrTest{name: "truncated op", err: ErrBadAddress, line: 5, detail: "op is truncated"},
+			func(bc *Bytecode) { bc.OpAddrs = bc.OpAddrs[:lineStart(bc, 5)+1] },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			corrupt := bc
+			corrupt.OpAddrs = append([]int(nil), bc.OpAddrs...)
+			test.corrupt(&corrupt)
+			var out bytes.Buffer
+			err := RunWithOptions(context.Background(), &corrupt, Options{Output: &out})
+			checkRuntimeErr(t, err, test.runtimeErrTest)
+			if out.Len() > 0 {
+				t.Errorf("ran ops before failing verification: printed %q", out.String())
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	const loop = "i = 0\nwhile true\n  i = i + 1\nend\n"
+	const grow = "nums = []\nwhile true\n  nums = append nums 1\nend\n"
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	tests := []struct {
+		name string
+		src  string
+		ctx  context.Context
+		opts Options
+		err  error
+	}{
+		{"steps", loop, context.Background(), Options{MaxSteps: 1000}, ErrStepLimit},
+		{"memory", grow, context.Background(), Options{MaxMemory: 1 << 16}, ErrMemoryLimit},
+		{"memory before any op", "s = 'abcdefgh'\nprint s\n", context.Background(), Options{MaxMemory: 4}, ErrMemoryLimit},
+		{"cancelled context", loop, cancelled, Options{}, context.Canceled},
+		{"context deadline", loop, timedOut, Options{}, context.DeadlineExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, err := Parse(strings.NewReader(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.opts.Output = new(bytes.Buffer)
+			err = RunWithOptions(test.ctx, &bc, test.opts)
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) || !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want a RuntimeError wrapping %v", err, test.err)
+			}
+		})
+	}
+}